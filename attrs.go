@@ -0,0 +1,86 @@
+package wpp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The attributes of the script and style elements wpp writes unless
+// ElementAttrs says otherwise.
+const (
+	DefaultScriptAttrs = `type="text/javascript"`
+	DefaultStyleAttrs  = `type="text/css"`
+)
+
+// ElementAttrs sets attributes of the script or style elements that
+// inline the files matching Pattern.
+type ElementAttrs struct {
+	// KindJS for script elements or KindCSS for style elements.
+	Kind Kind
+
+	// Path or glob relative to the input directories, with '**'
+	// matching any number of directories.  A directory matches
+	// every file below it and an empty pattern matches every file.
+	Pattern string
+
+	// Attributes as they appear in a start tag, for example
+	// `type="module" nonce="abc"`.
+	Attrs string
+}
+
+// ParseElementAttrs parses attributes for the elements of kind k from
+// s of the form "glob:attrs", for example `legacy/**:nomodule`.  If s
+// has no glob, or its first ':' follows an '=', the attributes apply
+// to every file.
+func ParseElementAttrs(k Kind, s string) (ElementAttrs, error) {
+	a := ElementAttrs{Kind: k, Attrs: s}
+
+	if i := strings.IndexByte(s, ':'); i >= 0 && !strings.ContainsAny(s[:i], `="'`) {
+		a.Pattern, a.Attrs = strings.TrimSpace(s[:i]), s[i+1:]
+	}
+	if len(parseAttrs(" "+a.Attrs)) == 0 {
+		return a, fmt.Errorf("Element attributes %q are not of the form \"[glob:]name=value ...\"", s)
+	}
+	return a, nil
+}
+
+// Returns the attributes, each with a leading space, of the script or
+// style element of kind k that inlines the file f.  Attributes of
+// every ElementAttrs matching f are merged over the defaults with a
+// later one replacing an attribute of the same name.  If f is nil only
+// the attributes that apply to every file are merged.
+func (b *Builder) elementAttrs(k Kind, f *input) string {
+	def := DefaultStyleAttrs
+	if k == KindJS {
+		def = DefaultScriptAttrs
+	}
+	attrs := parseAttrs(" " + def)
+
+	for _, e := range b.ElementAttrs {
+		if e.Kind != k {
+			continue
+		}
+		if pattern := cleanPattern(e.Pattern); pattern != "" && pattern != "**" {
+			if f == nil || !matchPath(pattern, f.path) {
+				continue
+			}
+		}
+
+	next:
+		for _, a := range parseAttrs(" " + e.Attrs) {
+			for i := range attrs {
+				if attrs[i].name == a.name {
+					attrs[i] = a
+					continue next
+				}
+			}
+			attrs = append(attrs, a)
+		}
+	}
+
+	var s strings.Builder
+	for _, a := range attrs {
+		s.WriteString(" " + strings.TrimSpace(a.text))
+	}
+	return s.String()
+}
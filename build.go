@@ -0,0 +1,444 @@
+package wpp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Build assembles the contents of the input directory into the HTML
+// template and writes the result to out.
+func (b *Builder) Build(ctx context.Context, out io.Writer) error {
+	return b.build(ctx, out, 0)
+}
+
+// Same as Build but inserts the hot reload code into the output when
+// reloadPort is greater than zero.
+func (b *Builder) build(ctx context.Context, out io.Writer, reloadPort uint) error {
+	html := ProgHtmlTemplate
+	if b.Template != "" {
+		var err error
+		if html, err = loadHtml(b.Template); err != nil {
+			return err
+		}
+	}
+	return b.preprocess(ctx, html, out, reloadPort)
+}
+
+// Pre-process the files from the input directory and writes the
+// output to out.  All the contents from the files in the input
+// directory will be spliced into the html template.
+func (b *Builder) preprocess(ctx context.Context, html string, out io.Writer, reloadPort uint) error {
+	files, _, err := b.gather(ctx)
+	if err != nil {
+		return err
+	}
+	if files, err = b.order(files); err != nil {
+		return err
+	}
+
+	p := &page{
+		Builder:   b,
+		ctx:       ctx,
+		files:     files,
+		placed:    make(map[*input]bool),
+		including: make(map[string]bool),
+		imported:  make(map[*input]bool),
+		inlined:   make(map[*input]bool),
+		modules:   make(map[*input]*module),
+		disk:      make(map[string]*input),
+		packages:  make(map[string]*packageJSON),
+		empty:     &input{path: "<empty>", data: []byte{}, kind: KindJS},
+	}
+
+	if reloadPort > 0 {
+		var code bytes.Buffer
+
+		reload, err := template.New("reload").Parse(ProgHotReloadCode)
+		if err != nil {
+			return err
+		} else if err := reload.Execute(&code, reloadPort); err != nil {
+			return err
+		}
+		p.reload = code.Bytes()
+	}
+
+	return p.execute(html, out)
+}
+
+// A bundle accumulates the contents of the files inlined into a
+// single HTML element.
+type bundle struct {
+	bytes.Buffer
+
+	// Written between the contents of two files.  The contents of
+	// every file already end with a newline.
+	sep string
+
+	// Precede the contents of each file with a comment naming it.
+	banners bool
+
+	// Number of files added.
+	count int
+
+	// Number of lines written.
+	lines int
+}
+
+// Adds the contents of the file name to the bundle and returns the
+// zero based line the contents start on.  The name is left out of the
+// banner when empty, as is done for code generated by wpp.
+func (u *bundle) add(name string, data []byte) int {
+	if u.count > 0 {
+		u.write([]byte(u.sep))
+	}
+	if u.banners && name != "" {
+		u.write([]byte(fmt.Sprintf("/* wpp: %s */\n", strings.Replace(name, "*/", "*\\/", -1))))
+	}
+
+	line := u.lines
+	u.write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		u.write([]byte("\n"))
+	}
+	u.count++
+	return line
+}
+
+func (u *bundle) write(data []byte) {
+	u.Write(data)
+	u.lines += bytes.Count(data, []byte("\n"))
+}
+
+// A Kind is the kind of content a file holds which determines where
+// it is inlined.
+type Kind int
+
+const (
+	// Not inlined as Javascript or CSS.
+	KindOther Kind = iota
+	KindJS
+	KindCSS
+)
+
+// The extensions of Javascript and CSS files used when a Builder has
+// no Extensions.
+var DefaultExtensions = map[string]Kind{
+	".js":  KindJS,
+	".mjs": KindJS,
+	".cjs": KindJS,
+	".css": KindCSS,
+}
+
+// ParseKind returns the kind named s, either "js" or "css".
+func ParseKind(s string) (Kind, error) {
+	switch strings.ToLower(s) {
+	case "js", "javascript":
+		return KindJS, nil
+	case "css":
+		return KindCSS, nil
+	}
+	return KindOther, fmt.Errorf(`Unknown kind %q, expected "js" or "css"`, s)
+}
+
+// ParseExtension parses a mapping of an extension to a kind of the
+// form "ext=kind" such as ".mjs=js".  The extension is returned in
+// lower case with a leading dot.
+func ParseExtension(s string) (string, Kind, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return "", KindOther, fmt.Errorf(`Extension mapping %q is not of the form "ext=js" or "ext=css"`, s)
+	}
+
+	ext := normalizeExt(s[:i])
+	if ext == "" {
+		return "", KindOther, fmt.Errorf("Extension mapping %q has an empty extension", s)
+	}
+
+	k, err := ParseKind(strings.TrimSpace(s[i+1:]))
+	return ext, k, err
+}
+
+func (k Kind) String() string {
+	switch k {
+	case KindJS:
+		return "js"
+	case KindCSS:
+		return "css"
+	}
+	return "other"
+}
+
+// Returns the kind of the file name by its extension, or the
+// extension of its transform's output, looked up in Extensions.  Both
+// the build and Watch use this to decide which files matter.
+func (b *Builder) kind(name string) Kind {
+	ext := strings.ToLower(path.Ext(name))
+	if t := b.transform(name); t != nil && t.OutExt != "" {
+		ext = normalizeExt(t.OutExt)
+	}
+
+	exts := b.Extensions
+	if exts == nil {
+		exts = DefaultExtensions
+	}
+	return exts[ext]
+}
+
+// An input is a single file gathered from the input directory.
+type input struct {
+	// Slash separated path relative to the input directory.
+	path string
+
+	// Path of the file on disk.
+	abs string
+
+	info os.FileInfo
+
+	// Where the file is inlined.
+	kind Kind
+
+	// Contents of the file once read.
+	data []byte
+
+	// Contents of the file once transformed.
+	output []byte
+}
+
+// Walks the input directories and gathers every regular file that is
+// not ignored by Ignore or by the .gitignore and .wppignore files of
+// the directories walked.  A file in a later input directory replaces
+// the file at the same relative path in an earlier one and the files
+// are returned in the lexical order of a walk of the merged
+// directories.  Also returned are the directories that followed
+// symbolic links resolve to.
+func (b *Builder) gather(ctx context.Context) ([]*input, []string, error) {
+	if len(b.InputDirs) == 0 {
+		return nil, nil, fmt.Errorf("No input directories")
+	}
+
+	var (
+		files  []*input
+		linked []string
+		byPath = make(map[string]int)
+	)
+
+	for _, indir := range b.InputDirs {
+		add := func(f *input) {
+			if i, ok := byPath[f.path]; ok {
+				files[i] = f
+			} else {
+				byPath[f.path] = len(files)
+				files = append(files, f)
+			}
+		}
+		link := func(dir string) {
+			linked = append(linked, dir)
+		}
+		if err := b.walk(ctx, indir, add, link); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(b.InputDirs) > 1 {
+		sort.SliceStable(files, func(i, j int) bool {
+			return walkLess(files[i].path, files[j].path)
+		})
+	}
+	return files, linked, nil
+}
+
+// Walks the input directory indir and calls add with every regular
+// file that is not ignored in lexical order.  Symbolic links to files
+// are added as the files they link to while symbolic links to
+// directories are skipped unless FollowSymlinks is set, in which case
+// link is called with the directory each followed link resolves to.  A
+// file reached by more than one path is only added the first time and
+// a link back to a directory that contains it is an error.
+func (b *Builder) walk(ctx context.Context, indir string, add func(*input), link func(string)) error {
+	var (
+		rules = make(ignoreRules)
+		seen  = make(map[string]string)
+		visit func(abs, rel string, parents []string) error
+	)
+
+	visit = func(abs, rel string, parents []string) error {
+		if err := rules.load(rel, abs); err != nil {
+			return err
+		}
+
+		entries, err := ioutil.ReadDir(abs)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var (
+				name    = info.Name()
+				full    = filepath.Join(abs, name)
+				relpath = name
+				islink  = info.Mode()&os.ModeSymlink != 0
+			)
+			if rel != "." {
+				relpath = rel + "/" + name
+			}
+
+			if islink {
+				if info, err = os.Stat(full); err != nil {
+					wlog("Skipping broken symbolic link", full, "--", err)
+					continue
+				} else if info.IsDir() && !b.FollowSymlinks {
+					continue
+				}
+			}
+
+			if b.ignored(relpath) || rules.ignored(relpath, info.IsDir()) {
+				continue
+			}
+
+			if info.IsDir() {
+				real, err := realPath(full)
+				if err != nil {
+					return err
+				}
+				for _, p := range parents {
+					if p == real {
+						return fmt.Errorf("Symbolic link loop: %s leads back to %s", full, real)
+					}
+				}
+				if islink && link != nil {
+					link(real)
+				}
+				if err := visit(full, relpath, append(parents, real)); err != nil {
+					return err
+				}
+			} else if info.Mode().IsRegular() {
+				if b.FollowSymlinks {
+					real, err := realPath(full)
+					if err != nil {
+						return err
+					}
+					if first, ok := seen[real]; ok {
+						b.vlog("Skipping", relpath, "which is the same file as", first)
+						continue
+					}
+					seen[real] = relpath
+				}
+
+				add(&input{
+					path: relpath,
+					abs:  full,
+					info: info,
+					kind: b.kind(relpath),
+				})
+			}
+		}
+		return nil
+	}
+
+	root, err := realPath(indir)
+	if err != nil {
+		return err
+	}
+	return visit(indir, ".", []string{root})
+}
+
+// Returns the absolute path of name with all symbolic links resolved.
+func realPath(name string) (string, error) {
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(real)
+}
+
+// Reports whether the slash separated path a comes before b in a walk
+// of a directory, which compares paths one element at a time.
+func walkLess(a, b string) bool {
+	for {
+		i := strings.IndexByte(a, '/')
+		j := strings.IndexByte(b, '/')
+
+		ea, eb := a, b
+		if i >= 0 {
+			ea = a[:i]
+		}
+		if j >= 0 {
+			eb = b[:j]
+		}
+
+		if ea != eb || i < 0 || j < 0 {
+			if ea == eb {
+				return i < 0 && j >= 0
+			}
+			return ea < eb
+		}
+		a, b = a[i+1:], b[j+1:]
+	}
+}
+
+// Reports whether the file or directory at the slash separated path
+// name, relative to the input directory, is ignored.
+func (b *Builder) ignored(name string) bool {
+	for _, re := range b.Ignore {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the contents of f, reading the file on first use.
+func (f *input) contents() ([]byte, error) {
+	const (
+		MinUint = uint(0)
+		MaxUint = ^MinUint
+		MaxInt  = int(MaxUint >> 1)
+	)
+
+	if f.data != nil {
+		return f.data, nil
+	}
+
+	sz := f.info.Size()
+	if sz >= int64(MaxInt) {
+		return nil, fmt.Errorf("Files larger than %v are not supported.", MaxInt)
+	}
+
+	// A file removed since the walk is treated as empty.
+	data, err := ioutil.ReadFile(f.abs)
+	if os.IsNotExist(err) {
+		data = []byte{}
+	} else if err != nil {
+		return nil, err
+	}
+	f.data = data
+	return data, nil
+}
+
+func loadHtml(file string) (string, error) {
+	_, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s does not exist", file)
+	} else if err != nil {
+		return "", fmt.Errorf("Could not read file info for %s -- %v", file, err)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Could not read template file, %s -- %v", file, err)
+	}
+	return string(b), nil
+}
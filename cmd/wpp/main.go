@@ -0,0 +1,569 @@
+// A web pre-processor that assembles a single HTML file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+
+	"github.com/0xABAD/wpp"
+)
+
+var (
+	OptOutfile     string
+	OptHelp        bool
+	OptVerbose     bool
+	OptDevmode     bool
+	OptDevport     uint
+	OptTemplate    string
+	OptIgnore      listFlag
+	OptBanners     bool
+	OptSourceMap   bool
+	OptTransforms  listFlag
+	OptExtensions  listFlag
+	OptSymlinks    bool
+	OptEmbed       bool
+	OptEmbedLimit  int64
+	OptEmbedStrict bool
+	OptDiscover    bool
+	OptSplit       bool
+	OptEntries     listFlag
+	OptIsolate     bool
+	OptFragments   string
+	OptData        listFlag
+	OptWorkers     string
+	OptScriptAttrs listFlag
+	OptStyleAttrs  listFlag
+)
+
+// A flag that may be given multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func init() {
+	flag.BoolVar(&OptHelp, "help", false, UsageHelp)
+	flag.BoolVar(&OptHelp, "h", false, UsageHelp)
+	flag.BoolVar(&OptVerbose, "verbose", false, UsageVerbose)
+	flag.BoolVar(&OptVerbose, "v", false, UsageVerbose)
+	flag.StringVar(&OptOutfile, "outfile", "", UsageOutfile)
+	flag.StringVar(&OptOutfile, "o", "", UsageOutfile)
+	flag.StringVar(&OptTemplate, "template", "", UsageTemplate)
+	flag.StringVar(&OptTemplate, "t", "", UsageTemplate)
+	flag.Var(&OptIgnore, "ignore", UsageIgnore)
+	flag.Var(&OptIgnore, "i", UsageIgnore)
+	flag.BoolVar(&OptBanners, "banners", false, UsageBanners)
+	flag.BoolVar(&OptSourceMap, "sourcemap", false, UsageSourceMap)
+	flag.BoolVar(&OptSplit, "split", false, UsageSplit)
+	flag.Var(&OptScriptAttrs, "scriptattrs", UsageScriptAttrs)
+	flag.Var(&OptStyleAttrs, "styleattrs", UsageStyleAttrs)
+	flag.Var(&OptTransforms, "transform", UsageTransforms)
+	flag.Var(&OptTransforms, "x", UsageTransforms)
+	flag.Var(&OptExtensions, "ext", UsageExtensions)
+	flag.BoolVar(&OptSymlinks, "symlinks", false, UsageSymlinks)
+	flag.BoolVar(&OptEmbed, "embed", false, UsageEmbed)
+	flag.Int64Var(&OptEmbedLimit, "embedlimit", 0, UsageEmbedLimit)
+	flag.BoolVar(&OptEmbedStrict, "embedstrict", false, UsageEmbedStrict)
+	flag.BoolVar(&OptDiscover, "discover", false, UsageDiscover)
+	flag.Var(&OptEntries, "entry", UsageEntries)
+	flag.BoolVar(&OptIsolate, "isolate", false, UsageIsolate)
+	flag.StringVar(&OptFragments, "fragments", "", UsageFragments)
+	flag.Var(&OptData, "data", UsageData)
+	flag.StringVar(&OptWorkers, "workers", "", UsageWorkers)
+	flag.BoolVar(&OptDevmode, "devmode", false, "enable the dev server for hot reloading")
+	flag.UintVar(&OptDevport, "devport", 8082, "port to use with dev server")
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, UsageProgram, "\n")
+		flag.PrintDefaults()
+	}
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func main() {
+	flag.Parse()
+	if OptHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	inputdirs := flag.Args()
+	if len(inputdirs) == 0 {
+		flog("No input directory specified.  See wpp -help.")
+	}
+
+	for _, inputdir := range inputdirs {
+		stat, err := os.Stat(inputdir)
+		if os.IsNotExist(err) {
+			flog(inputdir, "does not exist.  See wpp -help.")
+		} else if !stat.IsDir() {
+			flog(inputdir, "is not a directory.  See wpp -help.")
+		}
+	}
+
+	if OptTemplate != "" {
+		if _, err := os.Stat(OptTemplate); os.IsNotExist(err) {
+			flog(OptTemplate, "does not exist")
+		}
+	}
+
+	builder := wpp.Builder{
+		InputDirs:      inputdirs,
+		Template:       OptTemplate,
+		ReloadPort:     OptDevport,
+		Outfile:        OptOutfile,
+		Banners:        OptBanners,
+		SourceMap:      OptSourceMap,
+		SplitFiles:     OptSplit,
+		FollowSymlinks: OptSymlinks,
+		EmbedAssets:    OptEmbed,
+		EmbedLimit:     OptEmbedLimit,
+		EmbedStrict:    OptEmbedStrict,
+		DiscoverTags:   OptDiscover,
+		Entries:        OptEntries,
+		IsolateScopes:  OptIsolate,
+		Fragments:      OptFragments,
+		DataFiles:      OptData,
+		Workers:        OptWorkers,
+		Verbose:        OptVerbose,
+		OnServe:        openBrowser,
+	}
+
+	for _, i := range OptIgnore {
+		ignore, err := regexp.Compile(i)
+		if err != nil {
+			flog("Failed to compile regexp for", i, " --", err)
+		}
+		builder.Ignore = append(builder.Ignore, ignore)
+	}
+
+	if len(OptExtensions) > 0 {
+		builder.Extensions = make(map[string]wpp.Kind)
+		for ext, k := range wpp.DefaultExtensions {
+			builder.Extensions[ext] = k
+		}
+		for _, e := range OptExtensions {
+			ext, k, err := wpp.ParseExtension(e)
+			if err != nil {
+				flog(err)
+			}
+			builder.Extensions[ext] = k
+		}
+	}
+
+	for _, a := range OptScriptAttrs {
+		attrs, err := wpp.ParseElementAttrs(wpp.KindJS, a)
+		if err != nil {
+			flog(err)
+		}
+		builder.ElementAttrs = append(builder.ElementAttrs, attrs)
+	}
+
+	for _, a := range OptStyleAttrs {
+		attrs, err := wpp.ParseElementAttrs(wpp.KindCSS, a)
+		if err != nil {
+			flog(err)
+		}
+		builder.ElementAttrs = append(builder.ElementAttrs, attrs)
+	}
+
+	for _, x := range OptTransforms {
+		t, err := wpp.ParseTransform(x)
+		if err != nil {
+			flog(err)
+		}
+		builder.Transforms = append(builder.Transforms, t)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if OptDevmode {
+		if err := builder.Watch(ctx); err != nil {
+			flog(err)
+		}
+		fmt.Println()
+		vlog("Dev mode exited cleanly")
+		return
+	}
+
+	out := io.Writer(os.Stdout)
+	if OptOutfile != "" {
+		file, err := wpp.CreateOutfile(OptOutfile)
+		if err != nil {
+			flog(err)
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	if err := builder.Build(ctx, out); err != nil {
+		flog("Failed to pre-process", strings.Join(inputdirs, ", "), " --", err)
+	}
+}
+
+// Opens the outfile in the default browser once the dev server is
+// up.
+func openBrowser() {
+	cmd := exec.Command(OpenBrowserCommand, OptOutfile)
+	if err := cmd.Run(); err != nil {
+		elog("Failed to open", OptOutfile, "in browser --", err)
+	} else {
+		vlog(fmt.Sprintf(`Opening in browser with "%s %s"`,
+			OpenBrowserCommand,
+			OptOutfile))
+	}
+}
+
+// For logging errors.
+func elog(args ...interface{}) {
+	post := fmt.Sprintln(args...)
+	log.Output(2, ProgName+" [ERROR] "+post)
+}
+
+// For logging fatal errors.
+func flog(args ...interface{}) {
+	post := fmt.Sprintln(args...)
+	log.Output(2, ProgName+" [FATAL] "+post)
+	os.Exit(1)
+}
+
+// For logging verbose output.
+func vlog(args ...interface{}) {
+	if OptVerbose {
+		post := fmt.Sprintln(args...)
+		log.Output(2, ProgName+" [VERBOSE] "+post)
+	}
+}
+
+const (
+	ProgName         = wpp.ProgName
+	UsageHelp        = "prints this help"
+	UsageVerbose     = "print wpp's log output"
+	UsageOutfile     = "name of output file"
+	UsageTemplate    = "template HTML file to use"
+	UsageIgnore      = "regex of paths relative to inputdir to ignore, may be repeated"
+	UsageBanners     = "precede each inlined file with a comment naming it"
+	UsageSplit       = "inline each file in a script or style element of its own"
+	UsageScriptAttrs = "attributes of script elements as '[glob:]attrs', may be repeated"
+	UsageStyleAttrs  = "attributes of style elements as '[glob:]attrs', may be repeated"
+	UsageSourceMap   = "append an inline source map to the Javascript"
+	UsageTransforms  = "command to pipe files through, as 'ext[:outext]=command', may be repeated"
+	UsageEmbed       = "embed files referred to by CSS and the template as data URIs"
+	UsageEmbedLimit  = "size in bytes above which files are not embedded, 0 for no limit"
+	UsageEmbedStrict = "fail instead of warn when a file is over the embed limit"
+	UsageEntries     = "path or glob of a module entry to bundle with its imports, may be repeated"
+	UsageIsolate     = "run each Javascript file in a function scope of its own"
+	UsageFragments   = "glob of HTML fragments to inline as template elements, default '**/*.tmpl.html'"
+	UsageData        = "path or glob of JSON or text files scripts read with wpp.data, may be repeated"
+	UsageWorkers     = "glob of Javascript files to inline as web workers, default 'workers'"
+	UsageDiscover    = "inline the local scripts and stylesheets the template links to in place"
+	UsageSymlinks    = "follow symbolic links to directories in inputdir"
+	UsageExtensions  = "inline files with an extension as 'ext=js' or 'ext=css', may be repeated"
+	UsageProgram     = `wpp [options] inputdir [inputdir...]
+
+Wpp is a web pre-processor that reads web files from 'inputdir' and
+takes the contents of all Javascript and CSS files and embeds the
+contents into a single HTML file.  Wpp does not perform any
+transformation on the input and instead relies on other tools to
+perform such tasks.
+
+More than one 'inputdir' may be given in which case they are merged
+as overlays: a file in a later 'inputdir' replaces the file at the
+same relative path in an earlier one.  For example, a shared design
+system can be themed by a product directory with:
+
+    wpp design-system product
+
+The final contents will be insterted into the template file specified
+by -template command line flag.  This template file is expected to be
+an HTML file with two locations to insert the CSS and Javascript.  For
+example, suppose we have a template named index-template.html that
+contains:
+
+    <!doctype html>
+    <html>
+      <head>
+        {{.CSS}}
+        {{.Javascript}}
+      </head>
+      <body>
+        <h1>Hello, world!</h1>
+      </body>
+    </html>
+
+then wpp will insert all CSS content where '{{.CSS}}' tag is and
+surround the content with an appropriate style HTML tag, and will
+insert all Javascript where the '{{.Javascript}}' tag is and surround
+the content with an appropriate script HTML tag.
+
+The template may also place files itself with the following
+functions, each taking a path or glob relative to 'inputdir':
+
+    {{css "vendor/**"}}                style element with matching CSS
+    {{js "app/*.js"}}                  script element with matching Javascript
+    {{templates "cards/**"}}           template elements with matching fragments
+    {{data "config/*.json"}}           elements with matching data files
+    {{workers "workers/**"}}           elements with matching worker files
+    {{include "partials/header.html"}} matching files executed as templates
+    {{raw "banner.txt"}}               contents of matching files as is
+
+Files placed by these functions are left out of '{{.CSS}}',
+'{{.Javascript}}' and '{{.Templates}}', which hold everything else.
+
+Files matching a path or glob given to the data flag are inlined as
+data for scripts to read with wpp.data, keyed by the file's path
+relative to 'inputdir'.  A JSON file, which must be valid, becomes a
+<script type="application/json"> element whose id is its path and
+wpp.data returns its parsed value.  Any other file becomes a string:
+
+    wpp -data 'config/*.json' -data 'corpus/**' src
+
+    var config = wpp.data("config/app.json"),
+        words = wpp.data("corpus/words.txt").split("\n");
+
+Data files not placed by the data function precede the script
+elements of '{{.Javascript}}'.
+
+Javascript files in the workers directory of 'inputdir', or matching
+the glob given to the workers flag, are web workers.  Rather than
+being part of the page's scripts each is inlined in a <script
+type="text/js-worker"> element whose id is its path and started with
+wpp.worker, which runs it from a Blob URL:
+
+    var worker = wpp.worker("workers/search.js");
+    worker.postMessage({query: "wpp"});
+
+Worker files not placed by the workers function precede the script
+elements of '{{.Javascript}}'.
+
+HTML fragment files, those ending in .tmpl.html or matching the glob
+given to the fragments flag, are inlined by '{{.Templates}}' as
+template elements whose id is the file's path relative to 'inputdir'.
+Scripts reach the markup of components/card.tmpl.html with
+document.getElementById("components/card.tmpl.html").content.
+When the template never uses '{{.Templates}}' the fragments not
+placed by the templates function precede the elements of
+'{{.Javascript}}' instead.
+
+For complete control the template can range over '{{.Files}}', every
+file in 'inputdir' in output order.  Each has the fields Path
+(relative to 'inputdir'), Ext, Size and ModTime and the methods
+Contents and SHA256.  For example:
+
+    {{range .Files}}{{if eq .Ext ".css"}}
+    <style id="{{.Path}}">{{.Contents}}</style>
+    {{end}}{{end}}
+
+If a template file is not provided then wpp will provide a default
+that looks like:
+
+    <!doctype html>
+    <html>
+      <head>
+        <meta charset="utf-8">
+        {{.CSS}}
+        {{.Javascript}}
+      </head>
+      <body>{{.Templates}}</body>
+    </html>
+
+Note that wpp uses the text/template package Go lang's standard
+library to perform the text substitution and that assumes the inserted
+content is trusted as it was strictly written by the developer.  Wpp
+wasn't designed to process end user content; it is merely a
+pre-processor.  It does, however, escape any "</script" or "<!--"
+sequence in Javascript and any "</style" sequence in CSS so that
+string literals and comments containing them can not end the inlined
+element early.  Such a sequence that can not be escaped without
+changing the meaning of the file fails the build with the file and
+line that contains it.
+
+The contents of each file always end with a newline and consecutive
+Javascript files are also separated by a line holding a semicolon so
+that a file missing its final newline or semicolon can not run into
+the next file.  With the banners flag each file is also preceded by a
+comment such as '/* wpp: app/main.js */' naming where it came from.
+
+With the sourcemap flag wpp appends an inline source map to the
+Javascript so that browser devtools report errors against the
+original file and line rather than the assembled HTML file.  A file
+ending with a '//# sourceMappingURL=' comment, as written by
+minifiers and compilers, has its own source map, either a data URI or
+a file relative to it, merged into the output's map.
+
+By default the Javascript files share a single script element, so a
+syntax error in any one of them stops all of them from running.  With
+the split flag every file is inlined in a script or style element of
+its own, with a data-wpp-src attribute naming the file, so an error
+only affects its own file and is easy to attribute in the browser
+console.
+
+Files are inlined in the lexical order of their paths unless
+'inputdir' contains a file named wpp.order.  Each line of wpp.order is
+a path, directory, or glob relative to 'inputdir' ('**' matches any
+number of directories) and files are inlined in the order of the
+lines that first match them.  Files not listed follow in lexical
+order.  Blank lines and lines starting with '#' are skipped.  For
+example:
+
+    # vendor code must load first
+    vendor/jquery.js
+    vendor
+    app/**/*.js
+
+A Javascript or CSS file may also name the files it depends on with
+@wpp-requires directives in the comments at the top of the file:
+
+    // @wpp-requires ./util.js
+    /* @wpp-requires base.css */
+
+A required path is relative to the requiring file's directory, or to
+'inputdir' if no such file exists, and the required file is always
+inlined first.  Requiring a missing file or a cycle of requirements
+fails the build.
+
+Files and directories whose path relative to 'inputdir' matches a
+regular expression given to the ignore flag are left out of the
+build entirely, for example '-ignore "\.min\.js$" -ignore "^test/"'.
+Wpp also honors the .gitignore and .wppignore files in 'inputdir' and
+its subdirectories.  Both use the syntax of gitignore, including
+negated '!' patterns, and rules in a .wppignore take precedence over
+those in the .gitignore of the same directory.
+
+ES modules can not import one another once inlined, so Javascript
+files given to the entry flag are bundled instead.  Starting from each
+entry, wpp follows the import and export statements, such as "import
+{ x } from './util.js'", and the require calls of CommonJS files, such
+as "require('./util')", and inlines the entry as a single script that
+includes only the modules it reaches.  ES modules run once, in
+dependency order, each in a scope of its own.  CommonJS modules get
+their own module, exports and require and run the first time they are
+required.  A specifier may leave out its extension or name a
+directory with an index.js file.  Bare specifiers, like 'lodash',
+are looked up in the node_modules directories of 'inputdir', using
+the browser or main fields of package.json, entirely from the files
+on disk; node_modules may be, and usually is, ignored.  A specifier
+that does not resolve, or a cycle of ES module imports, fails the
+build.  Modules reached from an entry are not inlined on their own.
+With any entry, other Javascript in node_modules is only inlined
+where a template function such as js places it.
+
+Every top level var and function of a classic script is a global
+shared with all the others.  With the isolate flag each Javascript
+file instead runs in a function of its own, which keeps any "use
+strict" directive at the top of the file, and files share values
+explicitly through the wpp.exports object:
+
+    // js/config.js
+    wpp.exports.config = {debug: true};
+
+    // js/app.js
+    var config = wpp.exports.config;
+
+A name declared at the top level of more than one file is logged as a
+warning since the files no longer share it.
+
+Script elements are written with type="text/javascript" and style
+elements with type="text/css".  The scriptattrs and styleattrs flags
+set other attributes, either for every file or for the files below a
+directory or matching a glob given before a ':', and may be repeated
+with later flags taking precedence:
+
+    wpp -scriptattrs 'nonce="r4nd0m"' \
+        -scriptattrs 'modules:type="module"' \
+        -scriptattrs 'legacy/**:nomodule' src
+
+Consecutive files with the same attributes share an element, and a
+new element starts wherever the attributes change so that files keep
+their order.
+
+With the discover flag, an existing development page can serve as
+the template.  Each <script src="..."> and <link rel="stylesheet"
+href="..."> element of the template that refers to a file in
+'inputdir' is replaced, in place and in the author's order, with a
+script or style element inlining that file.  Attributes such as
+type="module" or media="print" are kept while those that only apply
+to fetching the file, like src, integrity or defer, are dropped.
+Discovered files are left out of {{.Javascript}} and {{.CSS}}.
+
+An @import rule in a CSS file that refers to another CSS file in
+'inputdir' is replaced with that file's contents, wrapped in an @media
+rule when the import has a media query.  Imported files are inlined
+once, where they are first imported, rather than in their own place
+and a cycle of imports fails the build.  Imports of other URLs are
+kept and moved to the start of the style element.
+
+With the embed flag, relative url() references in CSS, src
+attributes in the template and href attributes of its link elements
+that refer to files in 'inputdir' are replaced with base64 data URIs
+so that images, fonts and icons ship inside the HTML file as well.
+References in CSS are relative to the CSS file and those in the
+template relative to 'inputdir'.  Tags within comments and the
+bodies of script and style elements are left alone.  Files larger
+than the embedlimit flag are left as references with a warning, or
+fail the build with the embedstrict flag.
+
+Symbolic links to files in 'inputdir' are inlined as the files they
+link to, while symbolic links to directories are skipped unless the
+symlinks flag is given.  With the flag, a file reached by more than
+one path is only inlined once and a link leading back to one of its
+own parent directories fails the build rather than looping forever.
+
+Files ending in .js, .mjs and .cjs are inlined as Javascript and
+files ending in .css as CSS.  The ext flag adds other extensions, for
+example '-ext .pcss=css -ext .jsm=js'.
+
+Although wpp performs no transformations itself, the transform flag
+pipes every file with a given extension through an external command
+before the file is inlined.  The flag takes the form
+'ext[:outext]=command' where the optional outext names the kind of
+file the command outputs, and may be given once per extension:
+
+    wpp -transform '.js=uglifyjs' \
+        -transform '.scss:.css=sass --stdin' \
+        -transform '.ts:.js=esbuild --loader=ts' src
+
+The command runs in the file's directory with the file's contents on
+standard input and its standard output is inlined instead.  A command
+that exits with a non-zero status fails the build with the command's
+standard error.
+
+Finally, it should be noted that wpp provides a developer mode where
+it will watch the given input directory and the template file for any
+file changes and continually process the input as it changes.
+Furthermore, wpp will insert a small snippet of Javascript into the
+output to allow hot reloading with the browser.  By default when the
+devmode flag is set wpp will serve the final HTML output via port 8082
+on localhost unless a different port is specified as an argument to
+the devport flag.  If devport is set to 0 or outfile is not set then
+then devmode no longer serves HTML and perform hot reloading; instead,
+it merely watches each inputdir and dumps the output to stdout.
+
+Wpp builds a single HTML file whose name is specified by the outfile
+flag.  If the outfile flag is not specified then the output will be
+sent to standard out.  Note that the output flag can specify a path
+to the output file name with directories don't exist which will then
+be created.  For example, -output 'build/index.html' will create a
+directory named 'build' where wpp was called if it doesn't exist and
+place the output into index.html inside that directory.
+
+Wpp provides the following options:
+`
+)
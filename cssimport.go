@@ -0,0 +1,250 @@
+package wpp
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches an @import rule in CSS along with its media query list.
+	cssImport = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)|"([^"]*)"|'([^']*)')\s*([^;]*);`)
+
+	// Matches an @charset rule at the start of CSS.
+	cssCharset = regexp.MustCompile(`^\s*@charset\s+"[^"]*"\s*;`)
+)
+
+// Returns the CSS of f ready to be inlined into a style element: piped
+// through its transform, with its assets embedded, closing tags
+// escaped and @import rules of files in the input directories
+// replaced with their own contents.  Any other @import rules are
+// removed and returned so that they can be placed at the start of the
+// style element where they are still valid.  Stack holds the files
+// whose imports are being inlined.
+func (p *page) style(f *input, stack []*input) ([]byte, []string, error) {
+	p.inlined[f] = true
+
+	data, err := p.transformed(p.ctx, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data, err = p.embedCSS(f, data); err != nil {
+		return nil, nil, err
+	}
+	if data, err = escapeStyle(f.path, data); err != nil {
+		return nil, nil, err
+	}
+	return p.inlineImports(f, data, append(stack, f))
+}
+
+// Replaces each @import rule in the CSS data of f that refers to a
+// file in the input directories with that file's contents, wrapped in
+// @layer, @supports and @media rules for the conditions of the import.
+// A file is only inlined the first time it is imported and importing
+// a file that is still being inlined is an error.
+func (p *page) inlineImports(f *input, data []byte, stack []*input) ([]byte, []string, error) {
+	matches := cssImport.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data, nil, nil
+	}
+
+	var (
+		out      bytes.Buffer
+		external []string
+		last     = 0
+	)
+
+	for _, m := range matches {
+		var (
+			rule  = string(data[m[0]:m[1]])
+			ref   string
+			media = strings.TrimSpace(string(data[m[12]:m[13]]))
+		)
+		for g := 2; g <= 10; g += 2 {
+			if m[g] >= 0 {
+				ref += string(data[m[g]:m[g+1]])
+			}
+		}
+
+		out.Write(data[last:m[0]])
+		last = m[1]
+
+		if isAbsoluteURL(ref) {
+			external = append(external, rule)
+			continue
+		}
+
+		target := p.importTarget(f, ref)
+		if target == nil {
+			wlog(f.path, "imports", ref, "which is not in the input directories and can not be inlined")
+			out.WriteString(rule)
+			continue
+		}
+
+		for i, s := range stack {
+			if s == target {
+				var cycle []string
+				for _, c := range stack[i:] {
+					cycle = append(cycle, c.path)
+				}
+				cycle = append(cycle, target.path)
+				return nil, nil, fmt.Errorf("Cycle of @import rules: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		if p.inlined[target] {
+			p.vlog(f.path, "imports", target.path, "which is already inlined")
+			continue
+		}
+
+		content, ext, err := p.style(target, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+		external = append(external, ext...)
+		content = cssCharset.ReplaceAll(content, nil)
+
+		open, close := importConditions(media)
+		out.WriteString(open)
+		out.Write(content)
+		out.WriteString(close)
+	}
+
+	out.Write(data[last:])
+	return out.Bytes(), external, nil
+}
+
+// Returns the file in the input directories that the @import ref of
+// the CSS file f refers to or nil if there is none.
+func (p *page) importTarget(f *input, ref string) *input {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	name, err := url.PathUnescape(ref)
+	if err != nil || name == "" {
+		return nil
+	}
+	return p.byPath[path.Join(path.Dir(f.path), name)]
+}
+
+// Returns the start and end of the rules that keep the conditions of
+// an @import rule, the text following its URL, around the CSS it
+// imports: an @layer rule for a layer, an @supports rule for a
+// supports condition and an @media rule for the media query list.
+func importConditions(conds string) (open, close string) {
+	var (
+		rules []string
+		rest  = strings.TrimSpace(conds)
+	)
+
+	// Removes the function name from the start of rest and returns
+	// its argument.
+	function := func(name string) (string, bool) {
+		if len(rest) <= len(name) || !strings.EqualFold(rest[:len(name)], name) || rest[len(name)] != '(' {
+			return "", false
+		}
+		depth := 0
+		for i := len(name); i < len(rest); i++ {
+			switch rest[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					arg := strings.TrimSpace(rest[len(name)+1 : i])
+					rest = strings.TrimSpace(rest[i+1:])
+					return arg, true
+				}
+			}
+		}
+		return "", false
+	}
+
+	if name, ok := function("layer"); ok {
+		rules = append(rules, "@layer "+name)
+	} else if len(rest) >= 5 && strings.EqualFold(rest[:5], "layer") && (len(rest) == 5 || rest[5] == ' ') {
+		rules = append(rules, "@layer")
+		rest = strings.TrimSpace(rest[5:])
+	}
+	if cond, ok := function("supports"); ok {
+		rules = append(rules, "@supports ("+cond+")")
+	}
+	if rest != "" {
+		rules = append(rules, "@media "+rest)
+	}
+
+	for _, r := range rules {
+		open += r + " {\n"
+		close += "\n}"
+	}
+	return open, close
+}
+
+// Marks the files that the CSS files import so that they are only
+// inlined where they are imported.  A cycle of imports is an error.
+func (p *page) scanImports() error {
+	graph := make(map[*input][]*input)
+
+	for _, f := range p.files {
+		if f.kind != KindCSS {
+			continue
+		}
+
+		data, err := p.transformed(p.ctx, f)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range cssImport.FindAllSubmatch(data, -1) {
+			ref := string(m[1]) + string(m[2]) + string(m[3]) + string(m[4]) + string(m[5])
+			if isAbsoluteURL(ref) {
+				continue
+			}
+			if target := p.importTarget(f, ref); target != nil {
+				p.imported[target] = true
+				graph[f] = append(graph[f], target)
+			}
+		}
+	}
+
+	var (
+		done  = make(map[*input]bool)
+		stack []*input
+		visit func(f *input) error
+	)
+
+	visit = func(f *input) error {
+		for i, s := range stack {
+			if s == f {
+				var cycle []string
+				for _, c := range stack[i:] {
+					cycle = append(cycle, c.path)
+				}
+				cycle = append(cycle, f.path)
+				return fmt.Errorf("Cycle of @import rules: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if done[f] {
+			return nil
+		}
+
+		stack = append(stack, f)
+		for _, t := range graph[f] {
+			if err := visit(t); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		done[f] = true
+		return nil
+	}
+
+	for _, f := range p.files {
+		if err := visit(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package wpp
+
+import "testing"
+
+func TestImportConditions(t *testing.T) {
+	tests := []struct {
+		conds, open, close string
+	}{
+		{"", "", ""},
+		{"screen", "@media screen {\n", "\n}"},
+		{"supports(display: grid)", "@supports (display: grid) {\n", "\n}"},
+		{"SUPPORTS(not (display: grid)) print", "@supports (not (display: grid)) {\n@media print {\n", "\n}\n}"},
+		{"layer", "@layer {\n", "\n}"},
+		{"layer(base) screen", "@layer base {\n@media screen {\n", "\n}\n}"},
+		{"layered", "@media layered {\n", "\n}"},
+	}
+
+	for _, tt := range tests {
+		open, close := importConditions(tt.conds)
+		if open != tt.open || close != tt.close {
+			t.Errorf("importConditions(%q) = %q, %q, want %q, %q", tt.conds, open, close, tt.open, tt.close)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Code that defines wpp.data, the accessor scripts read data files
+// with, preceded by the contents of text data files.
+const (
+	dataOpen = `(function (wpp) {
+var text = wpp.text = wpp.text || {}, cache = {};
+`
+	dataClose = `wpp.data = wpp.data || function (name) {
+  if (Object.prototype.hasOwnProperty.call(wpp.text, name)) {
+    return wpp.text[name];
+  }
+  if (!Object.prototype.hasOwnProperty.call(cache, name)) {
+    var el = document.getElementById(name);
+    if (!el || el.type !== "application/json") {
+      throw new Error("No data file " + name);
+    }
+    cache[name] = JSON.parse(el.textContent);
+  }
+  return cache[name];
+};
+})(self.wpp || (self.wpp = {exports: {}}));
+`
+)
+
+// Reports whether f is a data file.
+func (b *Builder) isData(f *input) bool {
+	for _, pattern := range b.DataFiles {
+		if matchPath(cleanPattern(pattern), f.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the elements that inline the data files: a JSON script
+// element, identified by the file's path, for each JSON file and a
+// script holding the contents of every other file as a string keyed by
+// its path.  The script also defines wpp.data, which returns the parsed
+// value of a JSON file or the string of a text file given its path.
+// A JSON file that is not valid is an error.  Nothing is built while
+// claiming.
+func (p *page) dataElements(files []*input) (string, error) {
+	if p.claiming || len(files) == 0 {
+		return "", nil
+	}
+
+	var (
+		out  strings.Builder
+		code = bytes.NewBufferString(dataOpen)
+	)
+
+	for _, f := range files {
+		data, err := p.transformed(p.ctx, f)
+		if err != nil {
+			return "", err
+		}
+
+		if strings.ToLower(path.Ext(f.path)) != ".json" {
+			fmt.Fprintf(code, "text[%s] = %s;\n", jsString(f.path), jsString(string(data)))
+			continue
+		}
+
+		if !json.Valid(data) {
+			return "", fmt.Errorf("%s is not valid JSON", f.path)
+		}
+
+		// A '<' can only be within a JSON string where it can be
+		// escaped so no closing tag ends the element early.
+		data = bytes.Replace(bytes.TrimSpace(data), []byte("<"), []byte(`\u003c`), -1)
+
+		out.WriteString(`<script type="application/json" id="` + template.HTMLEscapeString(f.path) + `">`)
+		out.Write(data)
+		out.WriteString("</script>\n")
+	}
+	code.WriteString(dataClose)
+
+	script, err := p.block(KindJS, nil, code.Bytes(), p.elementAttrs(KindJS, nil))
+	if err != nil {
+		return "", err
+	}
+	return out.String() + script + "\n", nil
+}
@@ -0,0 +1,216 @@
+package wpp
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches an attribute within an HTML start tag.
+	htmlAttr = regexp.MustCompile(`\s([^\s=/>"']+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>"']+)))?`)
+
+	// Matches the end tag of a script element.
+	scriptEnd = regexp.MustCompile(`(?i)</script\s*>`)
+
+	// Matches a comment, which may be left unclosed, or a script or
+	// style element whose body is the first or second group.
+	htmlText = regexp.MustCompile(`(?is)<!--.*?(?:-->|$)|<script\b[^>]*>(.*?)</script\s*>|<style\b[^>]*>(.*?)</style\s*>`)
+)
+
+// Attributes of the tags found by discover that only apply to a file
+// that is fetched and so are dropped from the inlined element.
+var fetchAttrs = map[string]bool{
+	"src":            true,
+	"href":           true,
+	"rel":            true,
+	"as":             true,
+	"integrity":      true,
+	"crossorigin":    true,
+	"referrerpolicy": true,
+	"fetchpriority":  true,
+	"charset":        true,
+	"defer":          true,
+	"async":          true,
+}
+
+// A script or stylesheet link element of the template that discover
+// replaced.
+type tag struct {
+	kind  Kind
+	file  *input
+	attrs string
+}
+
+// Replaces each script element with a src attribute, and each link
+// element for a stylesheet, in the HTML html that refers to a file in
+// the input directories with an action that inlines the file in its
+// place.  The attributes of the element are kept except those that
+// only apply to a fetched file.  Elements that refer to anything else
+// are left as is, as is anything within comments or the bodies of
+// script and style elements.
+func (p *page) discover(html string) (string, error) {
+	var (
+		out  strings.Builder
+		last = 0
+		text = textRanges(html)
+	)
+
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(html, -1) {
+		if m[0] < last {
+			continue
+		}
+		for len(text) > 0 && text[0][1] <= m[0] {
+			text = text[1:]
+		}
+		if len(text) > 0 && text[0][0] <= m[0] {
+			continue
+		}
+
+		var (
+			elem  = strings.ToLower(html[m[2]:m[3]])
+			attrs = parseAttrs(html[m[0]:m[1]])
+			end   = m[1]
+			k     Kind
+			ref   string
+		)
+
+		switch elem {
+		case "script":
+			loc := scriptEnd.FindStringIndex(html[m[1]:])
+			if loc == nil || strings.TrimSpace(html[m[1]:m[1]+loc[0]]) != "" {
+				continue
+			}
+			k, ref, end = KindJS, attrValue(attrs, "src"), m[1]+loc[1]
+		case "link":
+			if !hasToken(attrValue(attrs, "rel"), "stylesheet") {
+				continue
+			}
+			k, ref = KindCSS, attrValue(attrs, "href")
+		default:
+			continue
+		}
+
+		if ref == "" || isAbsoluteURL(ref) || strings.Contains(ref, "{{") {
+			continue
+		}
+
+		name := ref
+		if i := strings.IndexAny(name, "?#"); i >= 0 {
+			name = name[:i]
+		}
+		f := p.byPath[path.Join(".", name)]
+		if f == nil {
+			wlog("Template refers to", ref, "which is not in the input directories and can not be inlined")
+			continue
+		} else if f.kind != k {
+			wlog("Template refers to", ref, "as", k, "but it is inlined as", f.kind)
+			continue
+		}
+
+		var keep []string
+		for _, a := range attrs {
+			if !fetchAttrs[a.name] {
+				keep = append(keep, a.text)
+			}
+		}
+		if k == KindJS && attrValue(attrs, "type") != "module" &&
+			(hasAttr(attrs, "defer") || hasAttr(attrs, "async")) {
+			wlog("Template script", ref, "is inlined and so is no longer deferred")
+		}
+
+		p.placed[f] = true
+		p.tags = append(p.tags, tag{kind: k, file: f, attrs: strings.Join(keep, "")})
+
+		out.WriteString(html[last:m[0]])
+		fmt.Fprintf(&out, "{{wppTag %d}}", len(p.tags)-1)
+		last = end
+	}
+
+	out.WriteString(html[last:])
+	return out.String(), nil
+}
+
+// Returns the ranges of the HTML html, in order, that are not markup:
+// comments and the bodies of script and style elements.
+func textRanges(html string) [][2]int {
+	var ranges [][2]int
+	for _, m := range htmlText.FindAllStringSubmatchIndex(html, -1) {
+		switch {
+		case m[2] >= 0:
+			ranges = append(ranges, [2]int{m[2], m[3]})
+		case m[4] >= 0:
+			ranges = append(ranges, [2]int{m[4], m[5]})
+		default:
+			ranges = append(ranges, [2]int{m[0], m[1]})
+		}
+	}
+	return ranges
+}
+
+// Returns the element that inlines the i'th tag replaced by discover.
+func (p *page) tag(i int) (string, error) {
+	if i < 0 || i >= len(p.tags) {
+		return "", fmt.Errorf("No discovered tag %d", i)
+	}
+	t := p.tags[i]
+	return p.element(t.kind, []*input{t.file}, nil, t.attrs)
+}
+
+// An attribute of an HTML start tag along with its text, including
+// the leading space.
+type attr struct {
+	name, value, text string
+}
+
+// Returns the attributes of the HTML start tag start.
+func parseAttrs(start string) []attr {
+	start = strings.TrimSuffix(strings.TrimSuffix(start, ">"), "/")
+	if i := strings.IndexAny(start, " \t\r\n\f"); i >= 0 {
+		start = start[i:]
+	} else {
+		return nil
+	}
+
+	var attrs []attr
+	for _, m := range htmlAttr.FindAllStringSubmatch(start, -1) {
+		attrs = append(attrs, attr{
+			name:  strings.ToLower(m[1]),
+			value: m[2] + m[3] + m[4],
+			text:  m[0],
+		})
+	}
+	return attrs
+}
+
+// Returns the value of the attribute name or "" if there is none.
+func attrValue(attrs []attr, name string) string {
+	for _, a := range attrs {
+		if a.name == name {
+			return strings.TrimSpace(a.value)
+		}
+	}
+	return ""
+}
+
+// Reports whether attrs has the attribute name.
+func hasAttr(attrs []attr, name string) bool {
+	for _, a := range attrs {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the space separated list of tokens s holds token,
+// ignoring case.
+func hasToken(s, token string) bool {
+	for _, t := range strings.Fields(s) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
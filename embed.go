@@ -0,0 +1,224 @@
+package wpp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches a url() reference in CSS.
+	cssURL = regexp.MustCompile(`(?i)\burl\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+
+	// Matches the start tag of an HTML element.
+	htmlTag = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)(\s[^>]*)?>`)
+
+	// Matches a src or href attribute within an HTML start tag.
+	htmlRef = regexp.MustCompile(`(?i)(\s(?:src|href)\s*=\s*)(?:"([^"]*)"|'([^']*)'|([^\s>"']+))`)
+)
+
+// Media types of common web assets that the mime package may not know
+// on every system.
+var assetTypes = map[string]string{
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+	".ico":   "image/x-icon",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".json":  "application/json",
+}
+
+// Returns the media type of the file name by its extension.
+func mediaType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := assetTypes[ext]; ok {
+		return t
+	} else if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Replaces the relative url() references in the CSS data of the file
+// f with data URIs of the files they refer to.  The url() of an
+// @import rule is left for inlineImports.
+func (p *page) embedCSS(f *input, data []byte) ([]byte, error) {
+	if !p.EmbedAssets {
+		return data, nil
+	}
+
+	matches := cssURL.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data, nil
+	}
+
+	var (
+		out  bytes.Buffer
+		last = 0
+	)
+
+	for _, m := range matches {
+		out.Write(data[last:m[0]])
+		last = m[1]
+
+		var (
+			match  = data[m[0]:m[1]]
+			ref    string
+			before = bytes.TrimRight(data[:m[0]], " \t\r\n")
+		)
+		for g := 2; g <= 6; g += 2 {
+			if m[g] >= 0 {
+				ref += string(data[m[g]:m[g+1]])
+			}
+		}
+
+		if hasSuffixFold(before, "@import") {
+			out.Write(match)
+			continue
+		}
+
+		uri, ok, err := p.embed(f.path, path.Dir(f.path), ref)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			out.Write(match)
+			continue
+		}
+		out.WriteString(`url("` + uri + `")`)
+	}
+
+	out.Write(data[last:])
+	return out.Bytes(), nil
+}
+
+// Replaces the relative src attributes of any element, and href
+// attributes of link elements, in the HTML html of the file name with
+// data URIs of the files they refer to.  References are relative to
+// the input directories.  Attributes holding template actions are
+// left as is, as is anything within comments or the bodies of script
+// and style elements.
+func (p *page) embedHTML(name, html string) (string, error) {
+	if !p.EmbedAssets {
+		return html, nil
+	}
+
+	var (
+		out  strings.Builder
+		last = 0
+		text = textRanges(html)
+		err  error
+	)
+
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(html, -1) {
+		for len(text) > 0 && text[0][1] <= m[0] {
+			text = text[1:]
+		}
+		if len(text) > 0 && text[0][0] <= m[0] {
+			continue
+		}
+
+		elem := strings.ToLower(html[m[2]:m[3]])
+		tag := htmlRef.ReplaceAllStringFunc(html[m[0]:m[1]], func(attr string) string {
+			if err != nil {
+				return attr
+			}
+
+			sub := htmlRef.FindStringSubmatch(attr)
+			ref := sub[2] + sub[3] + sub[4]
+			isHref := strings.HasPrefix(strings.ToLower(strings.TrimSpace(sub[1])), "href")
+
+			if isHref && elem != "link" || strings.Contains(ref, "{{") {
+				return attr
+			}
+
+			uri, ok, e := p.embed(name, ".", ref)
+			if err = e; !ok {
+				return attr
+			}
+			return sub[1] + `"` + uri + `"`
+		})
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(html[last:m[0]])
+		out.WriteString(tag)
+		last = m[1]
+	}
+
+	out.WriteString(html[last:])
+	return out.String(), nil
+}
+
+// Returns the data URI that replaces the reference ref, relative to
+// the slash separated directory dir, made by the file from.  Ok is
+// false if ref is left as is, which is the case for absolute URLs and
+// references to files that are not in the input directories or are
+// larger than EmbedLimit.  The latter is an error if EmbedStrict is
+// set.
+func (p *page) embed(from, dir, ref string) (uri string, ok bool, err error) {
+	if ref == "" || isAbsoluteURL(ref) {
+		return "", false, nil
+	}
+
+	name, frag := ref, ""
+	if i := strings.IndexByte(name, '#'); i >= 0 {
+		name, frag = name[:i], name[i:]
+	}
+	if i := strings.IndexByte(name, '?'); i >= 0 {
+		name = name[:i]
+	}
+	if name, err = url.PathUnescape(name); err != nil {
+		wlog(from, "refers to malformed URL", ref)
+		return "", false, nil
+	}
+
+	target := path.Join(dir, name)
+	f := p.byPath[target]
+	if f == nil || strings.HasPrefix(target, "../") {
+		wlog(from, "refers to", ref, "which is not in the input directories and can not be embedded")
+		return "", false, nil
+	}
+
+	if size := f.info.Size(); p.EmbedLimit > 0 && size > p.EmbedLimit {
+		msg := fmt.Sprintf("%s refers to %s which at %d bytes is over the embed limit of %d bytes",
+			from, f.path, size, p.EmbedLimit)
+		if p.EmbedStrict {
+			return "", false, fmt.Errorf("%s", msg)
+		}
+		wlog(msg)
+		return "", false, nil
+	}
+
+	data, err := f.contents()
+	if err != nil {
+		return "", false, err
+	}
+	return "data:" + mediaType(f.path) + ";base64," + base64.StdEncoding.EncodeToString(data) + frag, true, nil
+}
+
+// Reports whether ref is an absolute URL, a path from the root of a
+// site or only a fragment, none of which can be embedded.
+func isAbsoluteURL(ref string) bool {
+	if strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "#") {
+		return true
+	}
+	i := strings.IndexAny(ref, ":/?#")
+	return i > 0 && ref[i] == ':'
+}
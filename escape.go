@@ -0,0 +1,354 @@
+package wpp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The states of the lexers used to escape inlined content.
+const (
+	lexCode = iota
+	lexSingleQuote
+	lexDoubleQuote
+	lexTemplate
+	lexRegexp
+	lexLineComment
+	lexBlockComment
+)
+
+var lexNames = [...]string{
+	lexSingleQuote:  "string literal",
+	lexDoubleQuote:  "string literal",
+	lexTemplate:     "template literal",
+	lexRegexp:       "regular expression literal",
+	lexBlockComment: "comment",
+}
+
+// Keywords after which a '/' starts a regular expression rather than
+// a division.
+var regexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true,
+	"of": true, "new": true, "delete": true, "void": true, "throw": true,
+	"case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
+// Rewrites the Javascript src of the file name so that it can be
+// inlined into a script element without a "</script" or "<!--"
+// sequence ending or confusing the element early.  Inside string,
+// template and regular expression literals the sequences are escaped
+// so their value is unchanged, inside comments they are broken up and
+// inside code "</" is split into "< /".  Inside the code of a classic
+// script "<!--" starts a comment and is replaced by "//" while in a
+// module, as when module is set, it is a '<' followed by "!--" and so
+// is split into "< !--".  If the source can not be tokenized well
+// enough to tell these apart an error naming the file and line is
+// returned.
+//
+// Source without either sequence is returned unchanged.
+func escapeScript(name string, src []byte, module bool) ([]byte, error) {
+	if !containsFold(src, "</script") && !bytes.Contains(src, []byte("<!--")) {
+		return src, nil
+	}
+
+	var (
+		out     bytes.Buffer
+		state   = lexCode
+		line    = 1
+		start   = 1
+		inClass = false
+		last    = byte(0) // last significant character of code
+		word    []byte    // last identifier of code
+		braces  []int     // open braces within each template substitution
+	)
+	out.Grow(len(src))
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+
+		if c == '<' {
+			if hasPrefixFold(src[i:], "</script") {
+				if state == lexCode {
+					out.WriteString("< ")
+					last, word = c, nil
+				} else {
+					out.WriteString(`<\/`)
+					i++
+				}
+				continue
+			} else if bytes.HasPrefix(src[i:], []byte("<!--")) {
+				switch state {
+				case lexCode:
+					if module {
+						out.WriteString("< !--")
+						last, word = '-', nil
+						break
+					}
+					out.WriteString("//")
+					state = lexLineComment
+				case lexLineComment, lexBlockComment:
+					out.WriteString("< !--")
+				default:
+					out.WriteString(`\x3C!--`)
+				}
+				i += 3
+				continue
+			}
+		}
+
+		out.WriteByte(c)
+		if c == '\n' {
+			line++
+		}
+
+		switch state {
+		case lexCode:
+			switch {
+			case c == '\'':
+				state, start = lexSingleQuote, line
+			case c == '"':
+				state, start = lexDoubleQuote, line
+			case c == '`':
+				state, start = lexTemplate, line
+			case c == '/' && i+1 < len(src) && src[i+1] == '/':
+				state = lexLineComment
+				out.WriteByte('/')
+				i++
+			case c == '/' && i+1 < len(src) && src[i+1] == '*':
+				state, start = lexBlockComment, line
+				out.WriteByte('*')
+				i++
+			case c == '/' && regexpAllowed(last, word):
+				state, start, inClass = lexRegexp, line, false
+			case c == '{':
+				if n := len(braces); n > 0 {
+					braces[n-1]++
+				}
+				last, word = c, nil
+			case c == '}' && len(braces) > 0 && braces[len(braces)-1] == 0:
+				braces = braces[:len(braces)-1]
+				state = lexTemplate
+			case c == '}':
+				if n := len(braces); n > 0 {
+					braces[n-1]--
+				}
+				last, word = c, nil
+			case isIdentByte(c):
+				if i > 0 && isIdentByte(src[i-1]) {
+					word = append(word, c)
+				} else {
+					word = []byte{c}
+				}
+				last = c
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			default:
+				last, word = c, nil
+			}
+
+		case lexSingleQuote, lexDoubleQuote:
+			quote := byte('\'')
+			if state == lexDoubleQuote {
+				quote = '"'
+			}
+			switch c {
+			case '\\':
+				if i+1 < len(src) {
+					i++
+					out.WriteByte(src[i])
+					if src[i] == '\n' {
+						line++
+					}
+				}
+			case quote:
+				state, last, word = lexCode, ')', nil
+			case '\n':
+				return nil, unterminated(name, start, state)
+			}
+
+		case lexTemplate:
+			switch {
+			case c == '\\':
+				if i+1 < len(src) {
+					i++
+					out.WriteByte(src[i])
+					if src[i] == '\n' {
+						line++
+					}
+				}
+			case c == '`':
+				state, last, word = lexCode, ')', nil
+			case c == '$' && i+1 < len(src) && src[i+1] == '{':
+				out.WriteByte('{')
+				i++
+				braces = append(braces, 0)
+				state, last, word = lexCode, '{', nil
+			}
+
+		case lexRegexp:
+			switch {
+			case c == '\\':
+				if i+1 < len(src) && src[i+1] != '\n' {
+					i++
+					out.WriteByte(src[i])
+				}
+			case c == '[':
+				inClass = true
+			case c == ']':
+				inClass = false
+			case c == '/' && !inClass:
+				state, last, word = lexCode, ')', nil
+			case c == '\n':
+				return nil, unterminated(name, start, state)
+			}
+
+		case lexLineComment:
+			if c == '\n' {
+				state = lexCode
+			}
+
+		case lexBlockComment:
+			if c == '*' && i+1 < len(src) && src[i+1] == '/' {
+				out.WriteByte('/')
+				i++
+				state = lexCode
+			}
+		}
+	}
+
+	if state != lexCode && state != lexLineComment {
+		return nil, unterminated(name, start, state)
+	}
+	return out.Bytes(), nil
+}
+
+// Rewrites the CSS src of the file name so that it can be inlined
+// into a style element without a "</style" sequence ending the
+// element early.  The sequence is escaped inside strings and comments
+// and is an error, naming the file and line, anywhere else.
+//
+// Source without the sequence is returned unchanged.
+func escapeStyle(name string, src []byte) ([]byte, error) {
+	if !containsFold(src, "</style") {
+		return src, nil
+	}
+
+	var (
+		out   bytes.Buffer
+		state = lexCode
+		line  = 1
+		start = 1
+	)
+	out.Grow(len(src))
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+
+		if c == '<' && hasPrefixFold(src[i:], "</style") {
+			if state == lexCode {
+				return nil, fmt.Errorf("%s:%d: </style> outside of a string or comment can not be escaped", name, line)
+			}
+			out.WriteString(`<\/`)
+			i++
+			continue
+		}
+
+		out.WriteByte(c)
+		if c == '\n' {
+			line++
+		}
+
+		switch state {
+		case lexCode:
+			switch {
+			case c == '\'':
+				state, start = lexSingleQuote, line
+			case c == '"':
+				state, start = lexDoubleQuote, line
+			case c == '/' && i+1 < len(src) && src[i+1] == '*':
+				state, start = lexBlockComment, line
+				out.WriteByte('*')
+				i++
+			}
+
+		case lexSingleQuote, lexDoubleQuote:
+			quote := byte('\'')
+			if state == lexDoubleQuote {
+				quote = '"'
+			}
+			switch c {
+			case '\\':
+				if i+1 < len(src) {
+					i++
+					out.WriteByte(src[i])
+					if src[i] == '\n' {
+						line++
+					}
+				}
+			case quote:
+				state = lexCode
+			case '\n':
+				return nil, unterminated(name, start, state)
+			}
+
+		case lexBlockComment:
+			if c == '*' && i+1 < len(src) && src[i+1] == '/' {
+				out.WriteByte('/')
+				i++
+				state = lexCode
+			}
+		}
+	}
+
+	if state != lexCode {
+		return nil, unterminated(name, start, state)
+	}
+	return out.Bytes(), nil
+}
+
+func unterminated(name string, line, state int) error {
+	return fmt.Errorf("%s:%d: unterminated %s, can not safely escape closing tags", name, line, lexNames[state])
+}
+
+// Reports whether a '/' following the significant character last,
+// or the identifier word, of Javascript code starts a regular
+// expression.
+func regexpAllowed(last byte, word []byte) bool {
+	if word != nil {
+		return regexpKeywords[string(word)]
+	}
+	switch last {
+	case ')', ']', '}':
+		return false
+	}
+	return true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// Reports whether s begins with the ASCII string prefix, ignoring
+// case.
+func hasPrefixFold(s []byte, prefix string) bool {
+	return len(s) >= len(prefix) && bytes.EqualFold(s[:len(prefix)], []byte(prefix))
+}
+
+// Reports whether s contains the ASCII string sub, ignoring case.
+func containsFold(s []byte, sub string) bool {
+	for i := bytes.IndexByte(s, sub[0]); i >= 0; {
+		if hasPrefixFold(s[i:], sub) {
+			return true
+		}
+		j := bytes.IndexByte(s[i+1:], sub[0])
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return false
+}
+
+// Reports whether s ends with the ASCII string suffix, ignoring case.
+func hasSuffixFold(s []byte, suffix string) bool {
+	return len(s) >= len(suffix) && bytes.EqualFold(s[len(s)-len(suffix):], []byte(suffix))
+}
@@ -0,0 +1,68 @@
+package wpp
+
+import "testing"
+
+func TestEscapeScript(t *testing.T) {
+	tests := []struct {
+		src, want string
+		module    bool
+		err       bool
+	}{
+		{src: `var a = 1 < 2;`, want: `var a = 1 < 2;`},
+		{src: `var s = "</script>";`, want: `var s = "<\/script>";`},
+		{src: `var s = '</SCRIPT>';`, want: `var s = '<\/SCRIPT>';`},
+		{src: "var s = `${a}</script>`;", want: "var s = `${a}<\\/script>`;"},
+		{src: `var r = /</script>/;`, want: `var r = /<\/script>/;`},
+		{src: `if (a / b </script>/.source.length) x();`, want: `if (a / b < /script>/.source.length) x();`},
+		{src: "// </script>\nx();", want: "// <\\/script>\nx();"},
+		{src: `/* <!-- */ x();`, want: `/* < !-- */ x();`},
+		{src: `var s = "<!--";`, want: `var s = "\x3C!--";`},
+		{src: "x(); <!-- old\ny();", want: "x(); // old\ny();"},
+		{src: "if (a <!--b) x(\"<!--\");", module: true, want: "if (a < !--b) x(\"\\x3C!--\");"},
+		{src: `var s = "</script>`, err: true},
+		{src: "var s = `</script>", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := escapeScript("test.js", []byte(tt.src), tt.module)
+		if tt.err {
+			if err == nil {
+				t.Errorf("escapeScript(%q) = %q, want an error", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("escapeScript(%q) failed: %v", tt.src, err)
+		} else if string(got) != tt.want {
+			t.Errorf("escapeScript(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeStyle(t *testing.T) {
+	tests := []struct {
+		src, want string
+		err       bool
+	}{
+		{src: `a { color: red }`, want: `a { color: red }`},
+		{src: `a::after { content: "</style>" }`, want: `a::after { content: "<\/style>" }`},
+		{src: `a::after { content: '</STYLE>' }`, want: `a::after { content: '<\/STYLE>' }`},
+		{src: `/* </style> */ a {}`, want: `/* <\/style> */ a {}`},
+		{src: `a {} </style>`, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := escapeStyle("test.css", []byte(tt.src))
+		if tt.err {
+			if err == nil {
+				t.Errorf("escapeStyle(%q) = %q, want an error", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("escapeStyle(%q) failed: %v", tt.src, err)
+		} else if string(got) != tt.want {
+			t.Errorf("escapeStyle(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
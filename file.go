@@ -0,0 +1,53 @@
+package wpp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"time"
+)
+
+// A File describes an input file to the HTML template through its
+// .Files field.
+type File struct {
+	// Slash separated path relative to the input directory.
+	Path string
+
+	// Extension of Path, including the leading dot.
+	Ext string
+
+	// Size in bytes.
+	Size int64
+
+	ModTime time.Time
+
+	in *input
+}
+
+func newFile(in *input) *File {
+	return &File{
+		Path:    in.path,
+		Ext:     path.Ext(in.path),
+		Size:    in.info.Size(),
+		ModTime: in.info.ModTime(),
+		in:      in,
+	}
+}
+
+// Contents returns the contents of the file as is.  Unlike the
+// contents of .CSS and .Javascript no closing tags are escaped.
+func (f *File) Contents() (string, error) {
+	data, err := f.in.contents()
+	return string(data), err
+}
+
+// SHA256 returns the hex encoded SHA-256 checksum of the file's
+// contents.
+func (f *File) SHA256() (string, error) {
+	data, err := f.in.contents()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
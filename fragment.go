@@ -0,0 +1,49 @@
+package wpp
+
+import (
+	"strings"
+	"text/template"
+)
+
+// The glob of the HTML fragment files inlined as template elements
+// unless Fragments says otherwise.
+const DefaultFragments = "**/*.tmpl.html"
+
+// Reports whether f is an HTML fragment file.
+func (b *Builder) isFragment(f *input) bool {
+	pattern := b.Fragments
+	if pattern == "" {
+		pattern = DefaultFragments
+	}
+	return matchPath(cleanPattern(pattern), f.path)
+}
+
+// Returns a template element for each of the HTML fragment files,
+// identified by the file's path, holding the file's markup.  Nothing
+// is built while claiming.
+func (p *page) fragments(files []*input) (string, error) {
+	if p.claiming {
+		return "", nil
+	}
+
+	var out strings.Builder
+	for _, f := range files {
+		data, err := p.transformed(p.ctx, f)
+		if err != nil {
+			return "", err
+		}
+
+		html, err := p.embedHTML(f.path, string(data))
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(`<template id="` + template.HTMLEscapeString(f.path) + `">`)
+		out.WriteString(html)
+		if !strings.HasSuffix(html, "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString("</template>\n")
+	}
+	return out.String(), nil
+}
@@ -206,9 +206,9 @@ func main() {
 		flog("Errors encountered during dependency installation.")
 	}
 
-	vlog(`Dependencies installed, running "go install" for this package`)
+	vlog(`Dependencies installed, running "go install ./cmd/wpp" for this package`)
 	if !flagNoExecute {
-		cmd := exec.Command("go", "install")
+		cmd := exec.Command("go", "install", "./cmd/wpp")
 		if err := cmd.Run(); err != nil {
 			flog(`Failed installation with "go install" --`, err)
 		}
@@ -0,0 +1,45 @@
+package wpp
+
+import (
+	"path"
+	"strings"
+)
+
+// Reports whether name, a slash separated path, matches pattern.
+// Pattern has the syntax of path.Match with the addition that a "**"
+// element matches zero or more path elements.
+func matchGlob(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		} else if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Reports whether name matches pattern or is inside a directory that
+// matches pattern.
+func matchPath(pattern, name string) bool {
+	return matchGlob(pattern, name) || matchGlob(pattern+"/**", name)
+}
+
+// Cleans a slash separated pattern relative to the input directory.
+func cleanPattern(pattern string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(pattern)), "/")
+}
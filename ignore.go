@@ -0,0 +1,142 @@
+package wpp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the files, in the input directory or any of its
+// subdirectories, holding gitignore style rules for files to leave
+// out of the build.  Rules in a .wppignore take precedence over those
+// in a .gitignore of the same directory.
+const (
+	GitIgnoreName = ".gitignore"
+	WppIgnoreName = ".wppignore"
+)
+
+// A single rule of an ignore file.
+type ignoreRule struct {
+	// Glob matched against paths relative to the directory of the
+	// ignore file.
+	pattern string
+
+	// Re-includes what an earlier rule ignored.
+	negate bool
+
+	// Only matches directories.
+	dirOnly bool
+}
+
+// The rules of the ignore files found during a walk keyed by the
+// slash separated path, relative to the input directory, of their
+// directory.
+type ignoreRules map[string][]ignoreRule
+
+// Reads the ignore files of the directory dir, relative to the input
+// directory, found on disk at abs.
+func (r ignoreRules) load(dir, abs string) error {
+	for _, name := range [...]string{GitIgnoreName, WppIgnoreName} {
+		rules, err := readIgnoreFile(filepath.Join(abs, name))
+		if err != nil {
+			return err
+		}
+		r[dir] = append(r[dir], rules...)
+	}
+	return nil
+}
+
+// Reports whether the slash separated path name, relative to the
+// input directory, is ignored by the rules of its ancestor
+// directories.  As with git, the last rule to match decides and rules
+// of deeper directories come last.
+func (r ignoreRules) ignored(name string, isDir bool) bool {
+	var (
+		ignored = false
+		dir     = "."
+		rel     = name
+	)
+
+	for {
+		for _, rule := range r[dir] {
+			if (!rule.dirOnly || isDir) && matchGlob(rule.pattern, rel) {
+				ignored = !rule.negate
+			}
+		}
+
+		i := strings.IndexByte(rel, '/')
+		if i < 0 {
+			break
+		}
+		if dir == "." {
+			dir = rel[:i]
+		} else {
+			dir += "/" + rel[:i]
+		}
+		rel = rel[i+1:]
+	}
+
+	return ignored
+}
+
+// Reads the rules of the gitignore style file name.  A missing file
+// has no rules.
+func readIgnoreFile(name string) ([]ignoreRule, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var (
+		rules []ignoreRule
+		line  []byte
+	)
+	for rest := data; len(rest) > 0; {
+		line, rest = nextLine(rest)
+		if rule, ok := parseIgnoreRule(string(line)); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// Parses a line of a gitignore style file.  Blank lines and comments
+// are not rules.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return rule, false
+	}
+
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// A pattern with a slash is relative to the directory of the
+	// ignore file, otherwise it matches at any depth.
+	if strings.Contains(line, "/") {
+		rule.pattern = strings.TrimPrefix(line, "/")
+	} else {
+		rule.pattern = "**/" + line
+	}
+	return rule, true
+}
@@ -0,0 +1,131 @@
+package wpp
+
+import (
+	"sort"
+	"strings"
+)
+
+// Code that opens and closes the function each Javascript file runs in
+// when IsolateScopes is set.  The function is given the registry that
+// files share values through and is called with the global this so
+// the file sees the same this as a classic script.
+const (
+	isolateOpen  = "(function (wpp) {\n"
+	isolateClose = "}).call(this, self.wpp || (self.wpp = {exports: {}}));\n"
+)
+
+// Returns the Javascript data wrapped in a function of its own along
+// with sections offset by the line the function adds.
+func isolate(data []byte, sections []mapSection) ([]byte, []mapSection) {
+	out := make([]byte, 0, len(isolateOpen)+len(data)+len(isolateClose)+1)
+	out = append(out, isolateOpen...)
+	out = append(out, data...)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	out = append(out, isolateClose...)
+
+	for i := range sections {
+		sections[i].Offset.Line++
+	}
+	return out, sections
+}
+
+// Warns of each name declared at the top level of more than one of
+// the Javascript files that are isolated.  Those names were shared by
+// the files when inlined into a single script but no longer are.
+func (p *page) scanGlobals() {
+	declared := make(map[string][]string)
+
+	for _, f := range p.files {
+		if f.kind != KindJS || p.modules[f] != nil {
+			continue
+		}
+
+		data, err := p.transformed(p.ctx, f)
+		if err != nil {
+			// Reported when the file is inlined.
+			continue
+		}
+
+		names, err := topLevelNames(f.path, data)
+		if err != nil {
+			p.vlog("Could not find the top level names of", f.path, "--", err)
+			continue
+		}
+		for _, n := range names {
+			declared[n] = append(declared[n], f.path)
+		}
+	}
+
+	var dups []string
+	for n, files := range declared {
+		if len(files) > 1 {
+			dups = append(dups, n)
+		}
+	}
+	sort.Strings(dups)
+
+	for _, n := range dups {
+		wlog("Top level name", n, "is declared by", strings.Join(declared[n], ", "),
+			"and is no longer shared; use wpp.exports to share it")
+	}
+}
+
+// Returns the names declared by var, let, const, function and class
+// statements at the top level of the Javascript data of the file name.
+func topLevelNames(name string, data []byte) ([]string, error) {
+	toks, err := lexScript(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		names []string
+		seen  = make(map[string]bool)
+		add   = func(n string) {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	)
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.kind != tokIdent || t.depth != 0 {
+			continue
+		}
+		if i > 0 && !t.nl {
+			switch toks[i-1].text {
+			case ";", "}", ")":
+			default:
+				if toks[i-1].text != "async" || t.text != "function" {
+					continue
+				}
+			}
+		}
+
+		s := stmt{name: name, toks: toks, i: i + 1}
+		switch t.text {
+		case "var", "let", "const":
+			for {
+				n := s.next()
+				if n.kind != tokIdent {
+					break
+				}
+				add(n.text)
+				if !s.skipDeclarator() {
+					break
+				}
+			}
+			i = s.i - 1
+		case "function", "class":
+			s.accept("*")
+			if n := s.peek(); n.kind == tokIdent && n.text != "extends" {
+				add(n.text)
+			}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,283 @@
+package wpp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The kinds of Javascript tokens.
+const (
+	tokIdent = iota
+	tokNumber
+	tokString
+	tokTemplate
+	tokRegexp
+	tokPunct
+)
+
+// A Javascript token.  Comments and white space are skipped and the
+// tokens within the substitutions of a template literal are part of
+// the template token.
+type jsToken struct {
+	kind     int
+	text     string
+	pos, end int
+	line     int
+
+	// Set if a line break precedes the token.
+	nl bool
+
+	// Nesting of parentheses, brackets and braces the token is in.
+	depth int
+
+	// The tokens of each substitution of a template literal, with
+	// their nesting counted from the substitution.
+	subs [][]jsToken
+}
+
+// Returns the value of a string token.
+func (t jsToken) value() string {
+	if t.kind != tokString {
+		return t.text
+	}
+	s := t.text
+	if s[0] == '\'' {
+		s = doubleQuote(s[1 : len(s)-1])
+	}
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	return t.text[1 : len(t.text)-1]
+}
+
+// Returns the contents s of a single quoted Javascript string as a
+// double quoted one.
+func doubleQuote(s string) string {
+	out := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			if s[i+1] != '\'' {
+				out = append(out, s[i])
+			}
+			out = append(out, s[i+1])
+			i++
+		case s[i] == '"':
+			out = append(out, '\\', '"')
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(append(out, '"'))
+}
+
+// A lexer of Javascript source.
+type jsLexer struct {
+	name string
+	src  []byte
+	i    int
+	line int
+}
+
+// Returns the tokens of the Javascript src of the file name.  An
+// unterminated literal or comment is an error naming the file and
+// line.
+func lexScript(name string, src []byte) ([]jsToken, error) {
+	l := jsLexer{name: name, src: src, line: 1}
+	return l.tokens(false)
+}
+
+// Returns the tokens up to the end of the source or, if inTemplate is
+// set, the brace closing a template substitution.
+func (l *jsLexer) tokens(inTemplate bool) ([]jsToken, error) {
+	var (
+		toks  []jsToken
+		nl    bool
+		depth int
+	)
+
+	for l.i < len(l.src) {
+		c := l.src[l.i]
+		start := l.i
+
+		switch {
+		case c == '\n':
+			nl = true
+			l.line++
+			l.i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.i++
+			continue
+		case c == '/' && l.peek(1) == '/':
+			for l.i < len(l.src) && l.src[l.i] != '\n' {
+				l.i++
+			}
+			continue
+		case c == '/' && l.peek(1) == '*':
+			line := l.line
+			l.i += 2
+			for l.i < len(l.src) && !(l.src[l.i] == '*' && l.peek(1) == '/') {
+				if l.src[l.i] == '\n' {
+					nl = true
+					l.line++
+				}
+				l.i++
+			}
+			if l.i >= len(l.src) {
+				return nil, unterminated(l.name, line, lexBlockComment)
+			}
+			l.i += 2
+			continue
+		}
+
+		t := jsToken{pos: start, line: l.line, nl: nl, depth: depth}
+		nl = false
+
+		switch {
+		case c == '\'' || c == '"':
+			line := l.line
+			l.i++
+			for l.i < len(l.src) && l.src[l.i] != c {
+				switch l.src[l.i] {
+				case '\\':
+					l.i++
+					if l.i < len(l.src) && l.src[l.i] == '\n' {
+						l.line++
+					}
+				case '\n':
+					return nil, unterminated(l.name, line, lexDoubleQuote)
+				}
+				l.i++
+			}
+			if l.i >= len(l.src) {
+				return nil, unterminated(l.name, line, lexDoubleQuote)
+			}
+			l.i++
+			t.kind = tokString
+
+		case c == '`':
+			subs, err := l.template()
+			if err != nil {
+				return nil, err
+			}
+			t.kind, t.subs = tokTemplate, subs
+
+		case c == '/' && l.regexpAllowed(toks):
+			line := l.line
+			inClass := false
+			l.i++
+			for l.i < len(l.src) && (l.src[l.i] != '/' || inClass) {
+				switch l.src[l.i] {
+				case '\\':
+					l.i++
+				case '[':
+					inClass = true
+				case ']':
+					inClass = false
+				case '\n':
+					return nil, unterminated(l.name, line, lexRegexp)
+				}
+				l.i++
+			}
+			if l.i >= len(l.src) {
+				return nil, unterminated(l.name, line, lexRegexp)
+			}
+			l.i++
+			for l.i < len(l.src) && isIdentByte(l.src[l.i]) {
+				l.i++
+			}
+			t.kind = tokRegexp
+
+		case isIdentByte(c) || c == '\\':
+			for l.i < len(l.src) && (isIdentByte(l.src[l.i]) || l.src[l.i] == '\\' ||
+				'0' <= c && c <= '9' && l.src[l.i] == '.') {
+				l.i++
+			}
+			if '0' <= c && c <= '9' {
+				t.kind = tokNumber
+			} else {
+				t.kind = tokIdent
+			}
+
+		default:
+			l.i++
+			t.kind = tokPunct
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if c == '}' && depth == 0 && inTemplate {
+					return toks, nil
+				}
+				if depth > 0 {
+					depth--
+				}
+				t.depth = depth
+			}
+		}
+
+		t.end = l.i
+		t.text = string(l.src[t.pos:t.end])
+		toks = append(toks, t)
+	}
+
+	if inTemplate {
+		return nil, fmt.Errorf("%s:%d: unterminated template substitution", l.name, l.line)
+	}
+	return toks, nil
+}
+
+// Skips over the template literal starting at the current position
+// and returns the tokens of its substitutions.
+func (l *jsLexer) template() ([][]jsToken, error) {
+	var (
+		line = l.line
+		subs [][]jsToken
+	)
+	l.i++
+	for l.i < len(l.src) {
+		switch c := l.src[l.i]; {
+		case c == '\\':
+			l.i += 2
+			continue
+		case c == '\n':
+			l.line++
+		case c == '`':
+			l.i++
+			return subs, nil
+		case c == '$' && l.peek(1) == '{':
+			l.i += 2
+			toks, err := l.tokens(true)
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, toks)
+			continue
+		}
+		l.i++
+	}
+	return nil, unterminated(l.name, line, lexTemplate)
+}
+
+func (l *jsLexer) peek(n int) byte {
+	if l.i+n < len(l.src) {
+		return l.src[l.i+n]
+	}
+	return 0
+}
+
+// Reports whether a '/' following the tokens toks starts a regular
+// expression.
+func (l *jsLexer) regexpAllowed(toks []jsToken) bool {
+	if len(toks) == 0 {
+		return true
+	}
+	switch t := toks[len(toks)-1]; t.kind {
+	case tokIdent:
+		return regexpKeywords[t.text]
+	case tokPunct:
+		return regexpAllowed(t.text[0], nil)
+	}
+	return false
+}
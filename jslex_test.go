@@ -0,0 +1,172 @@
+package wpp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexScript(t *testing.T) {
+	const (
+		I = tokIdent
+		N = tokNumber
+		S = tokString
+		T = tokTemplate
+		R = tokRegexp
+		P = tokPunct
+	)
+
+	tests := []struct {
+		src   string
+		texts []string
+		kinds []int
+	}{
+		{
+			src:   `var a = 1.5;`,
+			texts: []string{"var", "a", "=", "1.5", ";"},
+			kinds: []int{I, I, P, N, P},
+		},
+		{
+			src:   `import x from './x.js' // comment`,
+			texts: []string{"import", "x", "from", "'./x.js'"},
+			kinds: []int{I, I, I, S},
+		},
+		{
+			src:   "f(`a ${ {b: `c`} } d`) /* } */",
+			texts: []string{"f", "(", "`a ${ {b: `c`} } d`", ")"},
+			kinds: []int{I, P, T, P},
+		},
+		{
+			src:   `x = a / b / c`,
+			texts: []string{"x", "=", "a", "/", "b", "/", "c"},
+			kinds: []int{I, P, I, P, I, P, I},
+		},
+		{
+			src:   `return /[/]"/g.test(s)`,
+			texts: []string{"return", `/[/]"/g`, ".", "test", "(", "s", ")"},
+			kinds: []int{I, R, P, I, P, I, P},
+		},
+		{
+			src:   `s = "a\"b" + 'c\'d'`,
+			texts: []string{"s", "=", `"a\"b"`, "+", `'c\'d'`},
+			kinds: []int{I, P, S, P, S},
+		},
+	}
+
+	for _, tt := range tests {
+		toks, err := lexScript("test.js", []byte(tt.src))
+		if err != nil {
+			t.Errorf("lexScript(%q) failed: %v", tt.src, err)
+			continue
+		}
+		var (
+			texts []string
+			kinds []int
+		)
+		for _, tok := range toks {
+			texts = append(texts, tok.text)
+			kinds = append(kinds, tok.kind)
+			if tt.src[tok.pos:tok.end] != tok.text {
+				t.Errorf("lexScript(%q): token %q has position %d:%d", tt.src, tok.text, tok.pos, tok.end)
+			}
+		}
+		if !reflect.DeepEqual(texts, tt.texts) {
+			t.Errorf("lexScript(%q) texts = %q, want %q", tt.src, texts, tt.texts)
+		}
+		if !reflect.DeepEqual(kinds, tt.kinds) {
+			t.Errorf("lexScript(%q) kinds = %v, want %v", tt.src, kinds, tt.kinds)
+		}
+	}
+}
+
+func TestLexScriptPositions(t *testing.T) {
+	src := "a(b,\n  /* x\n */ [c]) d"
+	toks, err := lexScript("test.js", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		text  string
+		line  int
+		nl    bool
+		depth int
+	}{
+		{"a", 1, false, 0},
+		{"(", 1, false, 0},
+		{"b", 1, false, 1},
+		{",", 1, false, 1},
+		{"[", 3, true, 1},
+		{"c", 3, false, 2},
+		{"]", 3, false, 1},
+		{")", 3, false, 0},
+		{"d", 3, false, 0},
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("lexScript(%q) returned %d tokens, want %d", src, len(toks), len(want))
+	}
+	for i, w := range want {
+		tok := toks[i]
+		if tok.text != w.text || tok.line != w.line || tok.nl != w.nl || tok.depth != w.depth {
+			t.Errorf("token %d = %q line %d nl %v depth %d, want %q line %d nl %v depth %d",
+				i, tok.text, tok.line, tok.nl, tok.depth, w.text, w.line, w.nl, w.depth)
+		}
+	}
+}
+
+func TestLexScriptTemplateSubstitutions(t *testing.T) {
+	src := "f(`a ${b + `${c}`} d ${e}`)"
+	toks, err := lexScript("test.js", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toks) != 4 || toks[2].kind != tokTemplate {
+		t.Fatalf("lexScript(%q) returned %d tokens, want a template third of 4", src, len(toks))
+	}
+
+	var texts []string
+	for _, sub := range toks[2].subs {
+		for _, tok := range sub {
+			texts = append(texts, tok.text)
+			if src[tok.pos:tok.end] != tok.text {
+				t.Errorf("substitution token %q has position %d:%d", tok.text, tok.pos, tok.end)
+			}
+		}
+	}
+	if want := []string{"b", "+", "`${c}`", "e"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("substitution tokens = %q, want %q", texts, want)
+	}
+}
+
+func TestLexScriptUnterminated(t *testing.T) {
+	for _, src := range []string{
+		`var s = "abc`,
+		"var s = 'a\nb'",
+		"var s = `abc",
+		"var s = `${a`",
+		`var r = /abc`,
+		`/* comment`,
+	} {
+		if _, err := lexScript("test.js", []byte(src)); err == nil {
+			t.Errorf("lexScript(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestTokenValue(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{`"a\"b"`, `a"b`},
+		{`'a\'b'`, `a'b`},
+		{`'a"b'`, `a"b`},
+		{`'A\n'`, "A\n"},
+		{`abc`, `abc`},
+	}
+	for _, tt := range tests {
+		kind := tokString
+		if tt.text[0] != '"' && tt.text[0] != '\'' {
+			kind = tokIdent
+		}
+		if got := (jsToken{kind: kind, text: tt.text}).value(); got != tt.want {
+			t.Errorf("value of %s = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
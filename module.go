@@ -0,0 +1,977 @@
+package wpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A module is a Javascript or JSON file reached from one of Entries
+// through import and export statements or require calls.  Its source
+// is rewritten so that it runs in a function of the entry's bundle.
+type module struct {
+	file  *input
+	entry bool
+
+	// Set for a CommonJS module, which runs the first time it is
+	// required, rather than an ES module, which runs before the
+	// modules that import it.
+	cjs bool
+
+	// Modules imported or required by this one in the order they
+	// are first named.
+	deps []*input
+
+	// The imports and exports of an ES module.  Exports, and the
+	// imports that are not live, are bound on the line opening the
+	// module's function so that the lines of body match those of
+	// the file.
+	imports []moduleImport
+	exports []moduleExport
+	stars   []*input
+
+	// The file's source with its import and export statements or
+	// require calls rewritten.  References to the imports of an ES
+	// module are rewritten once the modules it imports are loaded.
+	body []byte
+
+	// Source map sections of body when SourceMap is set.
+	sections []mapSection
+}
+
+// A variable an ES module binds to the export key, or the exports
+// object itself if key is "*", of the module from.
+type moduleImport struct {
+	local, key string
+	from       *input
+}
+
+// An export name of an ES module that reads the variable local or, if
+// from is set, the export key of the module from.
+type moduleExport struct {
+	name, local, key string
+	from             *input
+}
+
+// Code that starts a bundle of modules.  The registries of modules are
+// shared by every bundle in the page so a module reached from more
+// than one entry only runs once.
+const modulePrelude = `(function (__wpp_modules, __wpp_factories) {
+function __wpp_require(name) {
+  if (Object.prototype.hasOwnProperty.call(__wpp_modules, name)) {
+    return __wpp_modules[name];
+  }
+  var factory = __wpp_factories[name];
+  if (!factory) {
+    throw new Error("Cannot find module '" + name + "'");
+  }
+  var module = {exports: {}};
+  __wpp_modules[name] = module.exports;
+  factory.call(module.exports, module, module.exports, __wpp_require);
+  return __wpp_modules[name] = module.exports;
+}
+function __wpp_default(exports) {
+  return exports && exports.__esModule ? exports["default"] : exports;
+}
+function __wpp_export(exports, name, get) {
+  Object.defineProperty(exports, name, {enumerable: true, get: get});
+}
+function __wpp_exportAll(exports, from) {
+  Object.keys(from).forEach(function (name) {
+    if (name !== "default" && !Object.prototype.hasOwnProperty.call(exports, name)) {
+      __wpp_export(exports, name, function () { return from[name]; });
+    }
+  });
+}
+`
+
+// Code that ends a bundle of modules.
+const moduleEpilogue = `})(self.__wpp_modules || (self.__wpp_modules = {}),
+  self.__wpp_factories || (self.__wpp_factories = {}));
+`
+
+// Loads the modules reached from the files matching Entries.  An
+// import or require that can not be resolved or a cycle of imports is
+// an error.
+func (p *page) scanModules() error {
+	for _, e := range p.Entries {
+		var (
+			clean   = cleanPattern(e)
+			matched = false
+		)
+
+		for _, f := range p.files {
+			if !matchPath(clean, f.path) {
+				continue
+			}
+			matched = true
+
+			if f.kind != KindJS {
+				wlog("Entry", f.path, "is not Javascript")
+				continue
+			}
+			if err := p.loadModule(f, nil); err != nil {
+				return err
+			}
+			p.modules[f].entry = true
+		}
+
+		if !matched {
+			wlog("Entry", e, "does not match any file")
+		}
+	}
+	return nil
+}
+
+// Loads the module f along with every module it imports or requires.
+// Stack holds the ES modules importing f.  CommonJS modules may
+// require each other in a cycle, as they only run once required, but
+// ES modules may not.
+func (p *page) loadModule(f *input, stack []*input) error {
+	for i, s := range stack {
+		if s == f {
+			var cycle []string
+			for _, c := range stack[i:] {
+				cycle = append(cycle, c.path)
+			}
+			cycle = append(cycle, f.path)
+			return fmt.Errorf("Cycle of imports: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if p.modules[f] != nil {
+		return nil
+	}
+
+	m, err := p.parseModule(f)
+	if err != nil {
+		return err
+	}
+	p.modules[f] = m
+
+	if m.cjs {
+		stack = nil
+	} else {
+		stack = append(stack, f)
+	}
+	for _, d := range m.deps {
+		if err := p.loadModule(d, stack); err != nil {
+			return err
+		}
+	}
+
+	if !m.cjs {
+		return p.linkModule(m)
+	}
+	return nil
+}
+
+// Returns the module of the file f.  JSON files are CommonJS modules
+// exporting their value and Javascript files are ES modules if they
+// have an import or export statement and CommonJS modules otherwise.
+func (p *page) parseModule(f *input) (*module, error) {
+	m := &module{file: f, cjs: true}
+	if f == p.empty {
+		return m, nil
+	}
+
+	data, err := p.transformed(p.ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.kind != KindJS {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("%s is not valid JSON", f.path)
+		}
+		body := append([]byte("module.exports = "), bytes.TrimSpace(data)...)
+		if m.body, err = escapeScript(f.path, append(body, ";\n"...), false); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	if p.SourceMap {
+		if data, m.sections, err = sourceSections(f, data); err != nil {
+			return nil, err
+		}
+	}
+
+	toks, err := lexScript(f.path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, t := range toks {
+		if t.kind == tokIdent && t.depth == 0 && (t.text == "import" || t.text == "export") &&
+			(i == 0 || toks[i-1].text != "." && toks[i-1].text != "?.") &&
+			(i+1 == len(toks) || toks[i+1].text != "(" && toks[i+1].text != ".") {
+			m.cjs = false
+			break
+		}
+	}
+
+	if !m.cjs {
+		// Escaped by linkModule.
+		m.body, err = p.rewriteModule(m, data, toks)
+		return m, err
+	}
+
+	if data, err = p.rewriteRequires(m, data, toks); err != nil {
+		return nil, err
+	}
+	if m.body, err = escapeScript(f.path, data, false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Adds the file that the specifier spec made by the module m resolves
+// to as a dependency of m.
+func (p *page) dependOn(m *module, spec jsToken) (*input, error) {
+	target, err := p.resolveModule(m.file, spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range m.deps {
+		if d == target {
+			return target, nil
+		}
+	}
+	m.deps = append(m.deps, target)
+	return target, nil
+}
+
+// Returns the source data of the CommonJS module m with the name of
+// each require call made with a string literal replaced by the name
+// of the module it resolves to.
+func (p *page) rewriteRequires(m *module, data []byte, toks []jsToken) ([]byte, error) {
+	var (
+		out  bytes.Buffer
+		last = 0
+	)
+
+	for i := 0; i+1 < len(toks); i++ {
+		t := toks[i]
+		if t.kind != tokIdent || t.text != "require" || toks[i+1].text != "(" ||
+			i > 0 && (toks[i-1].text == "." || toks[i-1].text == "?.") {
+			continue
+		}
+
+		if i+3 >= len(toks) || toks[i+2].kind != tokString || toks[i+3].text != ")" {
+			wlog(fmt.Sprintf("%s:%d: require of a computed name can not be bundled", m.file.path, t.line))
+			continue
+		}
+
+		spec := toks[i+2]
+		target, err := p.dependOn(m, spec)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data[last:spec.pos])
+		out.WriteString(jsString(target.path))
+		last = spec.end
+		i += 3
+	}
+
+	out.Write(data[last:])
+	return out.Bytes(), nil
+}
+
+// Returns the source data of the ES module m with its import and
+// export statements removed.  Imports read the exports of the modules
+// they name, which have already run by the time m runs, once linkModule
+// rewrites their references, and exports become getters of the
+// module's exports object.
+func (p *page) rewriteModule(m *module, data []byte, toks []jsToken) ([]byte, error) {
+	var (
+		f    = m.file
+		out  bytes.Buffer
+		last = 0
+	)
+	// Replaces the source from pos up to end with repl followed by
+	// as many line breaks as were replaced.
+	cut := func(pos, end int, repl string) {
+		out.Write(data[last:pos])
+		out.WriteString(repl)
+		out.WriteString(strings.Repeat("\n", bytes.Count(data[pos:end], []byte("\n"))))
+		last = end
+	}
+
+	export := func(name, local string) {
+		m.exports = append(m.exports, moduleExport{name: name, local: local})
+	}
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.kind != tokIdent || t.depth != 0 || i > 0 && (toks[i-1].text == "." || toks[i-1].text == "?.") {
+			continue
+		}
+
+		s := stmt{name: f.path, toks: toks, i: i + 1}
+
+		switch t.text {
+		case "import":
+			if s.is("(") || s.is(".") {
+				continue
+			}
+
+			type binding struct{ local, key string }
+			var (
+				names []binding
+				spec  jsToken
+			)
+
+			if s.peek().kind == tokString {
+				spec = s.next()
+			} else {
+				if n := s.peek(); n.kind == tokIdent && !s.is("{") && !s.is("*") {
+					names = append(names, binding{s.next().text, "default"})
+					s.accept(",")
+				}
+				if s.accept("*") {
+					if err := s.expect("as"); err != nil {
+						return nil, err
+					}
+					names = append(names, binding{s.next().text, "*"})
+				} else if s.accept("{") {
+					for !s.accept("}") {
+						if s.done() {
+							return nil, s.errorf("unterminated import list")
+						}
+						key := s.next().value()
+						local := key
+						if s.accept("as") {
+							local = s.next().text
+						}
+						names = append(names, binding{local, key})
+						s.accept(",")
+					}
+				}
+				if err := s.expect("from"); err != nil {
+					return nil, err
+				}
+				spec = s.next()
+			}
+			if spec.kind != tokString {
+				return nil, s.errorf("expected a module specifier after import")
+			}
+			s.skipAttributes()
+			s.accept(";")
+
+			from, err := p.dependOn(m, spec)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range names {
+				m.imports = append(m.imports, moduleImport{local: n.local, key: n.key, from: from})
+			}
+			cut(t.pos, s.end(), "")
+			i = s.i - 1
+
+		case "export":
+			switch {
+			case s.accept("*"):
+				name := ""
+				if s.accept("as") {
+					name = s.next().value()
+				}
+				if err := s.expect("from"); err != nil {
+					return nil, err
+				}
+				spec := s.next()
+				if spec.kind != tokString {
+					return nil, s.errorf("expected a module specifier after export *")
+				}
+				s.skipAttributes()
+				s.accept(";")
+
+				from, err := p.dependOn(m, spec)
+				if err != nil {
+					return nil, err
+				}
+				if name != "" {
+					m.exports = append(m.exports, moduleExport{name: name, key: "*", from: from})
+				} else {
+					m.stars = append(m.stars, from)
+				}
+				cut(t.pos, s.end(), "")
+
+			case s.accept("{"):
+				type binding struct{ local, exported string }
+				var names []binding
+				for !s.accept("}") {
+					if s.done() {
+						return nil, s.errorf("unterminated export list")
+					}
+					local := s.next().value()
+					exported := local
+					if s.accept("as") {
+						exported = s.next().value()
+					}
+					names = append(names, binding{local, exported})
+					s.accept(",")
+				}
+
+				var from *input
+				if s.accept("from") {
+					spec := s.next()
+					if spec.kind != tokString {
+						return nil, s.errorf("expected a module specifier after from")
+					}
+					s.skipAttributes()
+					var err error
+					if from, err = p.dependOn(m, spec); err != nil {
+						return nil, err
+					}
+				}
+				s.accept(";")
+
+				for _, n := range names {
+					if from != nil {
+						m.exports = append(m.exports, moduleExport{name: n.exported, key: n.local, from: from})
+					} else {
+						export(n.exported, n.local)
+					}
+				}
+				cut(t.pos, s.end(), "")
+
+			case s.accept("default"):
+				decl := s.i
+				if s.is("async") && s.at(1).text == "function" {
+					s.next()
+				}
+				if s.is("function") || s.is("class") {
+					kw := s.next()
+					if kw.text == "function" {
+						s.accept("*")
+					}
+					if n := s.peek(); n.kind == tokIdent && n.text != "extends" {
+						cut(t.pos, toks[decl].pos, "")
+						export("default", n.text)
+						break
+					}
+				}
+				cut(t.pos, toks[decl].pos, "var __wpp_default_export = ")
+				export("default", "__wpp_default_export")
+
+			case s.is("var") || s.is("let") || s.is("const"):
+				cut(t.pos, s.peek().pos, "")
+				s.next()
+				for {
+					n := s.next()
+					if n.kind != tokIdent {
+						return nil, s.errorf("exporting a destructuring declaration is not supported")
+					}
+					export(n.text, n.text)
+					if !s.skipDeclarator() {
+						break
+					}
+				}
+
+			case s.is("function") || s.is("class") || s.is("async"):
+				cut(t.pos, s.peek().pos, "")
+				for s.is("async") || s.is("function") || s.is("class") || s.is("*") {
+					s.next()
+				}
+				n := s.peek()
+				if n.kind != tokIdent {
+					return nil, s.errorf("expected a name after export")
+				}
+				export(n.text, n.text)
+
+			default:
+				return nil, s.errorf("unsupported export statement")
+			}
+			i = s.i - 1
+		}
+	}
+	out.Write(data[last:])
+	return out.Bytes(), nil
+}
+
+// Rewrites each reference the ES module m makes to a live import as a
+// read of the export it imports, so that m sees the values later
+// assigned to the export, and then escapes the source of m, which is
+// module code wherever it is inlined.  Names declared again in a
+// nested scope are left alone within that scope.
+func (p *page) linkModule(m *module) error {
+	f := m.file
+	toks, err := lexScript(f.path, m.body)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]string)
+	for _, i := range m.imports {
+		if p.liveImport(i) {
+			live[i.local] = p.moduleValue(i.from, i.key)
+		}
+	}
+
+	var (
+		out    bytes.Buffer
+		last   = 0
+		scopes = declaredScopes(toks, 0, len(m.body))
+		refs   func(toks []jsToken)
+	)
+	refs = func(toks []jsToken) {
+		var open []string
+		for i, t := range toks {
+			if t.kind == tokTemplate {
+				for _, sub := range t.subs {
+					refs(sub)
+				}
+				continue
+			} else if t.kind == tokPunct {
+				switch t.text {
+				case "(", "[", "{":
+					open = append(open, t.text)
+				case ")", "]", "}":
+					if len(open) > 0 {
+						open = open[:len(open)-1]
+					}
+				}
+				continue
+			}
+
+			value, ok := live[t.text]
+			if t.kind != tokIdent || !ok || inScope(scopes[t.text], t.pos) {
+				continue
+			}
+
+			var prev, next string
+			if i > 0 {
+				prev = toks[i-1].text
+			}
+			if i+1 < len(toks) {
+				next = toks[i+1].text
+			}
+			if prev == "." && !spread(toks, i) {
+				continue // property
+			}
+			if prev == "{" || prev == "," {
+				if next == ":" {
+					continue // property name
+				}
+				if (next == "}" || next == ",") && len(open) > 0 && open[len(open)-1] == "{" {
+					value = t.text + ": " + value // shorthand property
+				}
+			}
+
+			out.Write(m.body[last:t.pos])
+			out.WriteString(value)
+			last = t.end
+		}
+	}
+	if len(live) > 0 {
+		refs(toks)
+	}
+	out.Write(m.body[last:])
+
+	m.body, err = escapeScript(f.path, out.Bytes(), true)
+	return err
+}
+
+// Reports whether the import i of an ES module is a live binding,
+// which is read from the module it names wherever it is referenced,
+// rather than a variable bound once when the module runs.  Imports of
+// the exports object of a module or of a CommonJS module's exports
+// are bound once.
+func (p *page) liveImport(i moduleImport) bool {
+	return i.key != "*" && !p.modules[i.from].cjs
+}
+
+// Returns the ranges of source, keyed by name, where the tokens toks
+// declare a variable, function, class or parameter.  A range is the
+// block, function or arrow function the declaration is in, or start
+// to end for a declaration outside of brackets.  Only the first name
+// of a declaration listing several is found.
+func declaredScopes(toks []jsToken, start, end int) map[string][][2]int {
+	var (
+		scopes = make(map[string][][2]int)
+
+		// Returns the index of the token closing the brackets
+		// opened at i.
+		closing = func(i int) int {
+			for j := i + 1; j < len(toks); j++ {
+				if toks[j].kind == tokPunct && toks[j].depth == toks[i].depth && strings.Contains(")]}", toks[j].text) {
+					return j
+				}
+			}
+			return len(toks) - 1
+		}
+
+		// Returns the range of the brackets opened at i extended
+		// by the body of the function or arrow function that
+		// they are the parameters of.
+		scope = func(i int) [2]int {
+			c := closing(i)
+			if c+1 < len(toks) && toks[c+1].text == "=" && c+2 < len(toks) && toks[c+2].text == ">" {
+				c += 2
+			}
+			if c+1 < len(toks) && toks[c+1].text == "{" {
+				c = closing(c + 1)
+			} else if c > closing(i) {
+				for c+1 < len(toks) && toks[c+1].depth >= toks[i].depth &&
+					!(toks[c+1].depth == toks[i].depth && (toks[c+1].text == "," || toks[c+1].text == ";")) {
+					c++
+				}
+			}
+			return [2]int{toks[i].pos, toks[c].end}
+		}
+
+		// Adds name as declared in the brackets enclosing the
+		// token at i.
+		declare = func(name string, i int) {
+			r := [2]int{start, end}
+			for j := i - 1; j >= 0; j-- {
+				if toks[j].kind == tokPunct && toks[j].depth == toks[i].depth-1 && strings.Contains("([{", toks[j].text) {
+					r = scope(j)
+					break
+				}
+			}
+			scopes[name] = append(scopes[name], r)
+		}
+
+		// Adds the names directly within the brackets opened at
+		// i, such as parameters or destructured variables, as
+		// declared in them.
+		bound = func(i int) {
+			r := scope(i)
+			for j := i + 1; j < len(toks) && toks[j].depth > toks[i].depth; j++ {
+				if t := toks[j]; t.kind == tokIdent && t.depth == toks[i].depth+1 &&
+					(strings.Contains("([{,", toks[j-1].text) || spread(toks, j)) {
+					scopes[t.text] = append(scopes[t.text], r)
+				}
+			}
+		}
+	)
+
+	for k, t := range toks {
+		if t.kind == tokTemplate {
+			for _, sub := range t.subs {
+				for name, r := range declaredScopes(sub, t.pos, t.end) {
+					scopes[name] = append(scopes[name], r...)
+				}
+			}
+			continue
+		}
+
+		switch t.text {
+		case "var", "let", "const", "class", "function", "catch":
+			j := k + 1
+			if j < len(toks) && toks[j].text == "*" {
+				j++
+			}
+			if j < len(toks) && toks[j].kind == tokIdent {
+				declare(toks[j].text, j)
+				j++
+			}
+			if j < len(toks) && (t.text != "class" && toks[j].text == "(" ||
+				t.text != "function" && t.text != "catch" && (toks[j].text == "{" || toks[j].text == "[")) {
+				bound(j)
+			}
+		case ">":
+			// The arrow of an arrow function is lexed as "=" and ">".
+			if k < 2 || toks[k-1].text != "=" || toks[k-1].end != t.pos {
+				continue
+			}
+			if prev := toks[k-2]; prev.kind == tokIdent {
+				r := [2]int{prev.pos, end}
+				if k+1 < len(toks) && toks[k+1].text == "{" {
+					r[1] = toks[closing(k+1)].end
+				} else {
+					for j := k + 1; j < len(toks); j++ {
+						if toks[j].depth < prev.depth || toks[j].depth == prev.depth && (toks[j].text == "," || toks[j].text == ";") {
+							r[1] = toks[j].pos
+							break
+						}
+					}
+				}
+				scopes[prev.text] = append(scopes[prev.text], r)
+			} else if prev.text == ")" {
+				for j := k - 3; j >= 0; j-- {
+					if toks[j].text == "(" && toks[j].depth == prev.depth {
+						bound(j)
+						break
+					}
+				}
+			}
+		}
+	}
+	return scopes
+}
+
+// Reports whether pos is within one of ranges.
+func inScope(ranges [][2]int, pos int) bool {
+	for _, r := range ranges {
+		if r[0] <= pos && pos < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the token at i of toks follows a "..." spread.
+func spread(toks []jsToken, i int) bool {
+	return i >= 3 && toks[i-1].text == "." && toks[i-2].text == "." && toks[i-3].text == "."
+}
+
+// Returns the bundle of the entry module m along with the source map
+// sections of the modules in it.  The factories of the CommonJS
+// modules are defined first and then the ES modules run in dependency
+// order ending with m, or m is required if it is a CommonJS module.
+func (p *page) bundleModules(m *module) ([]byte, []mapSection, error) {
+	var (
+		out      bundle
+		sections []mapSection
+		order    []*module
+		seen     = make(map[*input]bool)
+		visit    func(f *input)
+	)
+
+	visit = func(f *input) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		mod := p.modules[f]
+		for _, d := range mod.deps {
+			visit(d)
+		}
+		order = append(order, mod)
+	}
+	visit(m.file)
+
+	// Adds the body of mod, after the line head, followed by the line
+	// tail.
+	add := func(mod *module, head, tail string) {
+		out.write([]byte(head + "\n"))
+		line := out.add(mod.file.path, mod.body)
+		for _, s := range mod.sections {
+			s.Offset.Line += line
+			sections = append(sections, s)
+		}
+		out.write([]byte(tail + "\n"))
+	}
+
+	out.write([]byte(modulePrelude))
+
+	for _, mod := range order {
+		if mod.cjs {
+			key := "__wpp_factories[" + jsString(mod.file.path) + "]"
+			add(mod, key+" = "+key+" || function (module, exports, require) {", "};")
+		}
+	}
+
+	for _, mod := range order {
+		if !mod.cjs {
+			key := "__wpp_modules[" + jsString(mod.file.path) + "]"
+			add(mod, key+" = "+key+" || (function (__wpp_exports) { "+p.moduleHead(mod),
+				"return __wpp_exports;\n})(Object.create(null));")
+		}
+	}
+
+	if m.cjs {
+		out.write([]byte("__wpp_require(" + jsString(m.file.path) + ");\n"))
+	}
+
+	out.write([]byte(moduleEpilogue))
+	return out.Bytes(), sections, nil
+}
+
+// Returns the code that opens the function of the ES module m by
+// binding its imports that are not live and defining its exports.
+func (p *page) moduleHead(m *module) string {
+	var (
+		binds []string
+		head  = []string{`"use strict";`}
+	)
+
+	for _, i := range m.imports {
+		if !p.liveImport(i) {
+			binds = append(binds, i.local+" = "+p.moduleValue(i.from, i.key))
+		}
+	}
+	if len(binds) > 0 {
+		head = append(head, "var "+strings.Join(binds, ", ")+";")
+	}
+
+	for _, e := range m.exports {
+		value := e.local
+		if e.from != nil {
+			value = p.moduleValue(e.from, e.key)
+		}
+		for _, i := range m.imports {
+			if e.from == nil && i.local == e.local && p.liveImport(i) {
+				value = p.moduleValue(i.from, i.key)
+			}
+		}
+		head = append(head, "__wpp_export(__wpp_exports, "+jsString(e.name)+", function () { return "+value+"; });")
+	}
+	for _, from := range m.stars {
+		head = append(head, "__wpp_exportAll(__wpp_exports, "+p.moduleValue(from, "*")+");")
+	}
+
+	return strings.Join(head, " ")
+}
+
+// Returns the expression for the export key of the module f, or for
+// its exports object if key is "*".  The default export of a CommonJS
+// module is its module.exports unless it was compiled from an ES
+// module.
+func (p *page) moduleValue(f *input, key string) string {
+	if !p.modules[f].cjs {
+		if key == "*" {
+			return "__wpp_modules[" + jsString(f.path) + "]"
+		}
+		return "__wpp_modules[" + jsString(f.path) + "]" + jsProp(key)
+	}
+
+	exports := "__wpp_require(" + jsString(f.path) + ")"
+	switch key {
+	case "*":
+		return exports
+	case "default":
+		return "__wpp_default(" + exports + ")"
+	}
+	return exports + jsProp(key)
+}
+
+// Returns s as a Javascript string literal.
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Returns the expression that reads the property name of an object.
+func jsProp(name string) string {
+	for i := 0; i < len(name); i++ {
+		if !isIdentByte(name[i]) || i == 0 && '0' <= name[i] && name[i] <= '9' {
+			return "[" + jsString(name) + "]"
+		}
+	}
+	if name == "" {
+		return `[""]`
+	}
+	return "." + name
+}
+
+// A statement being parsed from the tokens of a file.
+type stmt struct {
+	name string
+	toks []jsToken
+	i    int
+}
+
+// Reports whether every token has been read.
+func (s *stmt) done() bool {
+	return s.i >= len(s.toks)
+}
+
+// Returns the token n after the next one without reading it.
+func (s *stmt) at(n int) jsToken {
+	if s.i+n < len(s.toks) {
+		return s.toks[s.i+n]
+	}
+	return jsToken{kind: -1}
+}
+
+// Returns the next token without reading it.
+func (s *stmt) peek() jsToken {
+	return s.at(0)
+}
+
+// Reads the next token.
+func (s *stmt) next() jsToken {
+	t := s.peek()
+	if !s.done() {
+		s.i++
+	}
+	return t
+}
+
+// Reports whether the next token is an identifier or punctuator with
+// the text text.
+func (s *stmt) is(text string) bool {
+	t := s.peek()
+	return (t.kind == tokIdent || t.kind == tokPunct) && t.text == text
+}
+
+// Reads the next token if it has the text text and reports whether it
+// did.
+func (s *stmt) accept(text string) bool {
+	if s.is(text) {
+		s.i++
+		return true
+	}
+	return false
+}
+
+// Reads the next token which must have the text text.
+func (s *stmt) expect(text string) error {
+	if !s.accept(text) {
+		return s.errorf("expected %s", text)
+	}
+	return nil
+}
+
+// Skips the import attributes of an import or export statement.
+func (s *stmt) skipAttributes() {
+	if !s.is("with") && !s.is("assert") || s.at(1).text != "{" {
+		return
+	}
+	s.next()
+	depth := s.peek().depth
+	for s.next(); !s.done(); {
+		if t := s.next(); t.text == "}" && t.depth == depth {
+			return
+		}
+	}
+}
+
+// Skips the rest of a declarator in a variable declaration and reports
+// whether another declarator follows.
+func (s *stmt) skipDeclarator() bool {
+	for !s.done() {
+		t := s.peek()
+		if t.depth == 0 && t.kind == tokPunct {
+			switch t.text {
+			case ",":
+				s.next()
+				return true
+			case ";":
+				s.next()
+				return false
+			}
+		}
+		if t.nl && t.depth == 0 && t.kind == tokIdent && s.i > 0 {
+			switch s.toks[s.i-1].text {
+			case ",", "=", "+", "-", "*", "/", "%", "?", ":", "&", "|", "^", "!", "~", "<", ">", "(", "[", "{", ".":
+			default:
+				return false
+			}
+		}
+		s.next()
+	}
+	return false
+}
+
+// Returns the offset just past the tokens read.
+func (s *stmt) end() int {
+	return s.toks[s.i-1].end
+}
+
+// Returns an error at the line of the next token.
+func (s *stmt) errorf(format string, args ...interface{}) error {
+	line := 0
+	if s.done() {
+		if len(s.toks) > 0 {
+			line = s.toks[len(s.toks)-1].line
+		}
+	} else {
+		line = s.peek().line
+	}
+	return fmt.Errorf("%s:%d: %s", s.name, line, fmt.Sprintf(format, args...))
+}
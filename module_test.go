@@ -0,0 +1,333 @@
+package wpp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Returns a page for the files, keyed by path, that reads their
+// contents from the map rather than from disk.
+func testPage(files map[string]string) *page {
+	b := &Builder{}
+	p := &page{
+		Builder:  b,
+		byPath:   make(map[string]*input),
+		placed:   make(map[*input]bool),
+		imported: make(map[*input]bool),
+		inlined:  make(map[*input]bool),
+		modules:  make(map[*input]*module),
+		disk:     make(map[string]*input),
+		packages: make(map[string]*packageJSON),
+		empty:    &input{path: "<empty>", data: []byte{}, kind: KindJS},
+	}
+
+	var paths []string
+	for name := range files {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	for _, name := range paths {
+		f := &input{path: name, data: []byte(files[name]), kind: b.kind(name)}
+		p.files = append(p.files, f)
+		p.byPath[name] = f
+	}
+	return p
+}
+
+var testModules = map[string]string{
+	"lib.js":                        "export var x = 1;\n",
+	"cjs.js":                        "module.exports = {name: 'cjs'};\n",
+	"dir/index.js":                  "module.exports = 2;\n",
+	"node_modules/pkg/package.json": `{"main": "main.js"}`,
+	"node_modules/pkg/main.js":      "module.exports = 3;\n",
+}
+
+func TestRewriteModule(t *testing.T) {
+	tests := []struct {
+		src, body string
+		imports   []string
+		exports   []string
+		stars     []string
+	}{
+		{
+			src:     "import a, {x as b} from \"./lib.js\";\nuse(a, b);",
+			body:    "\nuse(a, b);",
+			imports: []string{"a=default@lib.js", "b=x@lib.js"},
+		},
+		{
+			src:     "import * as ns from './lib'\nuse(ns.x)",
+			body:    "\nuse(ns.x)",
+			imports: []string{"ns=*@lib.js"},
+		},
+		{
+			src:     "import c from 'pkg'; import './dir';",
+			body:    " ",
+			imports: []string{"c=default@node_modules/pkg/main.js"},
+		},
+		{
+			src:     "export var a = 1, b = {c: 2};",
+			body:    "var a = 1, b = {c: 2};",
+			exports: []string{"a=a", "b=b"},
+		},
+		{
+			src:     "export async function f() {}\nexport class C {}",
+			body:    "async function f() {}\nclass C {}",
+			exports: []string{"f=f", "C=C"},
+		},
+		{
+			src:     "export default function () {}",
+			body:    "var __wpp_default_export = function () {}",
+			exports: []string{"default=__wpp_default_export"},
+		},
+		{
+			src:     "export default class A {}",
+			body:    "class A {}",
+			exports: []string{"default=A"},
+		},
+		{
+			src:     "export default\n  1 + 2;",
+			body:    "var __wpp_default_export = \n1 + 2;",
+			exports: []string{"default=__wpp_default_export"},
+		},
+		{
+			src:     "var a, c;\nexport {a as b, c};",
+			body:    "var a, c;\n",
+			exports: []string{"b=a", "c=c"},
+		},
+		{
+			src:     "export * from \"./lib.js\";\nexport * as ns from \"./lib.js\";\nexport {x as y} from './lib.js'",
+			body:    "\n\n",
+			exports: []string{"ns=*@lib.js", "y=x@lib.js"},
+			stars:   []string{"lib.js"},
+		},
+		{
+			src:  "import('./lib.js').then(f); import.meta.url; a.import;",
+			body: "import('./lib.js').then(f); import.meta.url; a.import;",
+		},
+	}
+
+	for _, tt := range tests {
+		p := testPage(testModules)
+		f := &input{path: "main.js", data: []byte(tt.src), kind: KindJS}
+		m := &module{file: f}
+
+		toks, err := lexScript(f.path, f.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := p.rewriteModule(m, f.data, toks)
+		if err != nil {
+			t.Errorf("rewriteModule(%q) failed: %v", tt.src, err)
+			continue
+		}
+
+		var imports, exports, stars []string
+		for _, i := range m.imports {
+			imports = append(imports, fmt.Sprintf("%s=%s@%s", i.local, i.key, i.from.path))
+		}
+		for _, e := range m.exports {
+			if e.from != nil {
+				exports = append(exports, fmt.Sprintf("%s=%s@%s", e.name, e.key, e.from.path))
+			} else {
+				exports = append(exports, e.name+"="+e.local)
+			}
+		}
+		for _, s := range m.stars {
+			stars = append(stars, s.path)
+		}
+
+		if string(body) != tt.body {
+			t.Errorf("rewriteModule(%q) body = %q, want %q", tt.src, body, tt.body)
+		}
+		if !reflect.DeepEqual(imports, tt.imports) {
+			t.Errorf("rewriteModule(%q) imports = %q, want %q", tt.src, imports, tt.imports)
+		}
+		if !reflect.DeepEqual(exports, tt.exports) {
+			t.Errorf("rewriteModule(%q) exports = %q, want %q", tt.src, exports, tt.exports)
+		}
+		if !reflect.DeepEqual(stars, tt.stars) {
+			t.Errorf("rewriteModule(%q) stars = %q, want %q", tt.src, stars, tt.stars)
+		}
+	}
+}
+
+func TestRewriteModuleErrors(t *testing.T) {
+	for _, src := range []string{
+		`import x from "./missing.js";`,
+		`import {x from "./lib.js";`,
+		`export var {a, b} = o;`,
+		`export * from x;`,
+	} {
+		p := testPage(testModules)
+		f := &input{path: "main.js", data: []byte(src), kind: KindJS}
+
+		toks, err := lexScript(f.path, f.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.rewriteModule(&module{file: f}, f.data, toks); err == nil {
+			t.Errorf("rewriteModule(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestRewriteRequires(t *testing.T) {
+	tests := []struct {
+		src, body string
+		deps      []string
+		err       bool
+	}{
+		{
+			src:  `var a = require("./cjs.js"), b = require('./dir');`,
+			body: `var a = require("cjs.js"), b = require("dir/index.js");`,
+			deps: []string{"cjs.js", "dir/index.js"},
+		},
+		{
+			src:  "var c = require('pkg');\nvar d = require('pkg');",
+			body: "var c = require(\"node_modules/pkg/main.js\");\nvar d = require(\"node_modules/pkg/main.js\");",
+			deps: []string{"node_modules/pkg/main.js"},
+		},
+		{
+			src:  `a.require("./cjs.js"); require(name); require("./lib" + ext);`,
+			body: `a.require("./cjs.js"); require(name); require("./lib" + ext);`,
+		},
+		{
+			src: `require("./missing");`,
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		p := testPage(testModules)
+		f := &input{path: "main.js", data: []byte(tt.src), kind: KindJS}
+		m := &module{file: f, cjs: true}
+
+		toks, err := lexScript(f.path, f.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := p.rewriteRequires(m, f.data, toks)
+		if tt.err {
+			if err == nil {
+				t.Errorf("rewriteRequires(%q) succeeded, want an error", tt.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rewriteRequires(%q) failed: %v", tt.src, err)
+			continue
+		}
+
+		var deps []string
+		for _, d := range m.deps {
+			deps = append(deps, d.path)
+		}
+		if string(body) != tt.body {
+			t.Errorf("rewriteRequires(%q) body = %q, want %q", tt.src, body, tt.body)
+		}
+		if !reflect.DeepEqual(deps, tt.deps) {
+			t.Errorf("rewriteRequires(%q) deps = %q, want %q", tt.src, deps, tt.deps)
+		}
+	}
+}
+
+func TestLinkModule(t *testing.T) {
+	tests := []struct {
+		src, body string
+	}{
+		{
+			src:  "import {count, inc} from './util.js';\ninc(); log(count, {count}, o.count, {count: 1}, [...count]);",
+			body: "\n__wpp_modules[\"util.js\"].inc(); log(__wpp_modules[\"util.js\"].count, {count: __wpp_modules[\"util.js\"].count}, o.count, {count: 1}, [...__wpp_modules[\"util.js\"].count]);",
+		},
+		{
+			src:  "import {count as n} from './util.js';\nlog(`${n}`);",
+			body: "\nlog(`${__wpp_modules[\"util.js\"].count}`);",
+		},
+		{
+			src:  "import {count} from './util.js';\nfunction f(count) { return count; }\nvar g = count => count, h = (a, count) => count;\nlog(count);",
+			body: "\nfunction f(count) { return count; }\nvar g = count => count, h = (a, count) => count;\nlog(__wpp_modules[\"util.js\"].count);",
+		},
+		{
+			src:  "import {count} from './util.js';\nif (a) { let count = 1; log(count); }\nlog(count);",
+			body: "\nif (a) { let count = 1; log(count); }\nlog(__wpp_modules[\"util.js\"].count);",
+		},
+		{
+			src:  "import c from './cjs.js';\nimport * as ns from './util.js';\nlog(c, ns.count);",
+			body: "\n\nlog(c, ns.count);",
+		},
+	}
+
+	for _, tt := range tests {
+		files := map[string]string{
+			"main.js": tt.src,
+			"util.js": "export let count = 0;\nexport function inc() { count++; }\n",
+			"cjs.js":  testModules["cjs.js"],
+		}
+		p := testPage(files)
+		if err := p.loadModule(p.byPath["main.js"], nil); err != nil {
+			t.Errorf("loadModule(%q) failed: %v", tt.src, err)
+			continue
+		}
+		if body := string(p.modules[p.byPath["main.js"]].body); body != tt.body {
+			t.Errorf("loadModule(%q) body = %q, want %q", tt.src, body, tt.body)
+		}
+	}
+}
+
+// Live imports are not bound to variables and exporting one exports
+// the export it imports.
+func TestModuleHeadLiveImports(t *testing.T) {
+	p := testPage(map[string]string{
+		"main.js": "import {count} from './util.js';\nimport c from './cjs.js';\nexport {count as total};\n",
+		"util.js": "export let count = 0;\n",
+		"cjs.js":  testModules["cjs.js"],
+	})
+	main := p.byPath["main.js"]
+	if err := p.loadModule(main, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `"use strict"; var c = __wpp_default(__wpp_require("cjs.js")); ` +
+		`__wpp_export(__wpp_exports, "total", function () { return __wpp_modules["util.js"].count; });`
+	if head := p.moduleHead(p.modules[main]); head != want {
+		t.Errorf("moduleHead = %q, want %q", head, want)
+	}
+}
+
+// The variable holding a module's default export must not hide the
+// helper that reads the default export of a CommonJS module.
+func TestDefaultExportOfCommonJSDefault(t *testing.T) {
+	p := testPage(map[string]string{
+		"main.js": "import lib from './cjs.js';\nexport default lib.name;\n",
+		"cjs.js":  testModules["cjs.js"],
+	})
+	main := p.byPath["main.js"]
+
+	if err := p.loadModule(main, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := p.bundleModules(p.modules[main])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		`function __wpp_default(exports) {`,
+		`var lib = __wpp_default(__wpp_require("cjs.js"));`,
+		`var __wpp_default_export = lib.name;`,
+		`__wpp_export(__wpp_exports, "default", function () { return __wpp_default_export; });`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("bundle does not contain %q:\n%s", want, out)
+		}
+	}
+	for _, decl := range []string{"var __wpp_default ", "var __wpp_default="} {
+		if strings.Contains(out, decl) {
+			t.Errorf("bundle declares a variable hiding __wpp_default:\n%s", out)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package wpp
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Name of the optional file in the input directory that lists the
+// order of the files in the output.
+const ManifestName = "wpp.order"
+
+// Orders files according to the manifest in the input directory and
+// then moves each file after the files it requires.
+func (b *Builder) order(files []*input) ([]*input, error) {
+	files, err := b.orderManifest(files)
+	if err != nil {
+		return nil, err
+	}
+	return sortRequires(files)
+}
+
+// Orders files according to the manifest in the input directories.
+// Each line of the manifest is a path or glob relative to the input
+// directory and the files that match are placed in the order of the
+// lines that first match them.  Files not listed in the manifest
+// follow in walk order.
+func (b *Builder) orderManifest(files []*input) ([]*input, error) {
+	var entries []string
+
+	for _, f := range files {
+		if f.path == ManifestName {
+			data, err := f.contents()
+			if err != nil {
+				return nil, err
+			}
+			if entries, err = readManifest(data); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if len(entries) == 0 {
+		return files, nil
+	}
+
+	var (
+		ordered = make([]*input, 0, len(files))
+		placed  = make(map[*input]bool)
+	)
+
+	for _, e := range entries {
+		matched := false
+		for _, f := range files {
+			if matchPath(e, f.path) {
+				matched = true
+				if !placed[f] {
+					placed[f] = true
+					ordered = append(ordered, f)
+				}
+			}
+		}
+		if !matched {
+			wlog(ManifestName, "entry", e, "does not match any file")
+		}
+	}
+
+	for _, f := range files {
+		if !placed[f] {
+			ordered = append(ordered, f)
+		}
+	}
+	return ordered, nil
+}
+
+// Reads the entries of the manifest data.  Blank lines and lines
+// starting with '#' are skipped.
+func readManifest(data []byte) ([]string, error) {
+	var (
+		entries []string
+		line    []byte
+	)
+	for rest := data; len(rest) > 0; {
+		line, rest = nextLine(rest)
+		if line = bytes.TrimSpace(line); len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		entries = append(entries, cleanPattern(string(line)))
+	}
+	return entries, nil
+}
+
+// The directive a Javascript or CSS file uses in its header comments
+// to name the files that must precede it in the output.
+const RequiresDirective = "@wpp-requires"
+
+// Sorts files so that every Javascript and CSS file follows the files
+// named by its @wpp-requires directives.  Otherwise the order of files
+// is kept.  Requiring a file that does not exist or a cycle of
+// requirements is an error.
+func sortRequires(files []*input) ([]*input, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		sorted = make([]*input, 0, len(files))
+		byPath = make(map[string]*input, len(files))
+		state  = make(map[*input]int, len(files))
+		stack  []*input
+		visit  func(f *input) error
+	)
+
+	for _, f := range files {
+		byPath[f.path] = f
+	}
+
+	visit = func(f *input) error {
+		switch state[f] {
+		case visited:
+			return nil
+		case visiting:
+			var cycle []string
+			for i := len(stack) - 1; i >= 0; i-- {
+				cycle = append([]string{stack[i].path}, cycle...)
+				if stack[i] == f {
+					break
+				}
+			}
+			cycle = append(cycle, f.path)
+			return fmt.Errorf("Cycle of %s directives: %s", RequiresDirective, strings.Join(cycle, " -> "))
+		}
+
+		state[f] = visiting
+		stack = append(stack, f)
+
+		reqs, err := requires(f)
+		if err != nil {
+			return err
+		}
+		for _, r := range reqs {
+			dep := resolveRequire(byPath, f.path, r)
+			if dep == nil {
+				return fmt.Errorf("%s requires %s which does not exist", f.path, r)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[f] = visited
+		sorted = append(sorted, f)
+		return nil
+	}
+
+	for _, f := range files {
+		if err := visit(f); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Finds the file that the file from requires by name.  Name is
+// relative to the directory of from or, failing that, to the input
+// directory.
+func resolveRequire(byPath map[string]*input, from, name string) *input {
+	if f, ok := byPath[cleanPattern(path.Join(path.Dir(from), name))]; ok {
+		return f
+	}
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		return nil
+	}
+	return byPath[cleanPattern(name)]
+}
+
+// Returns the names listed by the @wpp-requires directives in the
+// leading comments of a Javascript or CSS file.  Scanning stops at the
+// first line that is not blank or a comment.
+func requires(f *input) ([]string, error) {
+	if f.kind == KindOther {
+		return nil, nil
+	}
+
+	data, err := f.contents()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		names   []string
+		inBlock = false
+		line    []byte
+	)
+
+	// Lines are only sliced from data, never copied, since the
+	// first line of code may be a minified file's only line.
+	for rest := data; len(rest) > 0; {
+		line, rest = nextLine(rest)
+		line = bytes.TrimSpace(line)
+
+		if !inBlock {
+			if len(line) == 0 {
+				continue
+			} else if bytes.HasPrefix(line, []byte("//")) {
+				names = append(names, directiveArgs(string(line[2:]))...)
+				continue
+			} else if !bytes.HasPrefix(line, []byte("/*")) {
+				break
+			}
+			line = line[2:]
+			inBlock = true
+		}
+
+		if i := bytes.Index(line, []byte("*/")); i >= 0 {
+			names = append(names, directiveArgs(string(line[:i]))...)
+			inBlock = false
+			if len(bytes.TrimSpace(line[i+2:])) != 0 {
+				break
+			}
+		} else {
+			names = append(names, directiveArgs(string(line))...)
+		}
+	}
+
+	return names, nil
+}
+
+// Returns the first line of data, without its line break, and the
+// data that follows it.
+func nextLine(data []byte) (line, rest []byte) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, nil
+}
+
+// Returns the names following a @wpp-requires directive in the text
+// of a comment.
+func directiveArgs(comment string) []string {
+	fields := strings.Fields(strings.TrimLeft(strings.TrimSpace(comment), "*"))
+	if len(fields) == 0 || fields[0] != RequiresDirective {
+		return nil
+	}
+	return fields[1:]
+}
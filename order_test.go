@@ -0,0 +1,76 @@
+package wpp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Longer than the 64 KB a bufio.Scanner allows a line.
+var longLine = strings.Repeat("x", 1<<17)
+
+func TestReadManifest(t *testing.T) {
+	data := "# comment\n\na.js\r\n  ./lib/*.js  \n#b.js\nc.js\n# " + longLine + "\n" + longLine + ".js"
+
+	got, err := readManifest([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.js", "lib/*.js", "c.js", longLine + ".js"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readManifest = %.40q, want %.40q", got, want)
+	}
+}
+
+func TestRequires(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []string
+	}{
+		{
+			name: "a.js",
+			src:  "// @wpp-requires b.js c.js\n/* @wpp-requires d.js */\nvar a;\n// @wpp-requires e.js",
+			want: []string{"b.js", "c.js", "d.js"},
+		},
+		{
+			name: "a.js",
+			src:  "/*\n * @wpp-requires b.js\n * @wpp-requires c.js\n */\n\nvar a;",
+			want: []string{"b.js", "c.js"},
+		},
+		{
+			name: "a.css",
+			src:  "/* @wpp-requires b.css */ a {}\n/* @wpp-requires c.css */",
+			want: []string{"b.css"},
+		},
+		{
+			name: "a.js",
+			src:  "// @wpp-requires b.js\n" + longLine + "\n// @wpp-requires c.js",
+			want: []string{"b.js"},
+		},
+		{
+			name: "a.js",
+			src:  "// " + longLine + "\n// @wpp-requires b.js\n" + longLine,
+			want: []string{"b.js"},
+		},
+		{
+			name: "a.js",
+			src:  "/* " + longLine + " */\r\n// @wpp-requires b.js\r\n",
+			want: []string{"b.js"},
+		},
+		{
+			name: "a.txt",
+			src:  "// @wpp-requires b.js",
+		},
+	}
+
+	b := &Builder{}
+	for _, tt := range tests {
+		f := &input{path: tt.name, data: []byte(tt.src), kind: b.kind(tt.name)}
+		got, err := requires(f)
+		if err != nil {
+			t.Errorf("requires(%.40q) failed: %v", tt.src, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requires(%.40q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package wpp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// A page is the state of a single execution of the HTML template.
+type page struct {
+	*Builder
+	ctx context.Context
+
+	// Files gathered from the input directory in output order.
+	files []*input
+
+	// The same files keyed by path.
+	byPath map[string]*input
+
+	// Files placed by the template functions.  Any other Javascript
+	// and CSS files go in the .Javascript and .CSS fields.
+	placed map[*input]bool
+
+	// Set while the template is executed the first time only to
+	// learn which files the template functions place.
+	claiming bool
+
+	// Files being executed by the include template function.
+	including map[string]bool
+
+	// CSS files imported by other CSS files and the CSS files
+	// already inlined into a style element.
+	imported map[*input]bool
+	inlined  map[*input]bool
+
+	// Modules reached from Entries, the files read from node_modules
+	// directories for them, the package.json files of their
+	// directories and the module that a package's browser field
+	// replaces a file with to leave it out.
+	modules  map[*input]*module
+	disk     map[string]*input
+	packages map[string]*packageJSON
+	empty    *input
+
+	// Script and stylesheet link elements of the template replaced
+	// when DiscoverTags is set.
+	tags []tag
+
+	// Hot reload code appended to the .Javascript field.
+	reload []byte
+
+	// Data the template is currently executed with.
+	data pageData
+}
+
+// The data the HTML template is executed with.
+type pageData struct {
+	// Style element with the CSS files not placed by a template
+	// function.
+	CSS string
+
+	// Script element with the Javascript files not placed by a
+	// template function preceded by the elements of the data and
+	// worker files not placed by one.  When the template never
+	// writes .Templates these are preceded by its elements too.
+	Javascript string
+
+	// Template elements with the HTML fragment files not placed by
+	// a template function.
+	Templates string
+
+	// Every file in the input directory in output order.
+	Files []*File
+}
+
+// Stands in for .Templates while claiming so that execute can tell
+// whether the template writes it.
+const templatesMark = "\x00wpp-templates\x00"
+
+// Executes the HTML template html and writes the result to out.  The
+// template is executed twice, the first time with its output
+// discarded, so that .CSS and .Javascript can leave out the files
+// that the template functions place no matter where in the template
+// either is used.
+func (p *page) execute(html string, out io.Writer) error {
+	p.byPath = make(map[string]*input, len(p.files))
+	for _, f := range p.files {
+		p.byPath[f.path] = f
+		p.data.Files = append(p.data.Files, newFile(f))
+	}
+
+	var err error
+	if p.DiscoverTags {
+		if html, err = p.discover(html); err != nil {
+			return err
+		}
+	}
+
+	if html, err = p.embedHTML("template", html); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("html").Funcs(p.funcs()).Parse(html)
+	if err != nil {
+		return err
+	}
+
+	var claimed bytes.Buffer
+	p.claiming = true
+	p.data.Templates = templatesMark
+	if err := tmpl.Execute(&claimed, p.data); err != nil {
+		return err
+	}
+	p.claiming = false
+	writesTemplates := bytes.Contains(claimed.Bytes(), []byte(templatesMark))
+
+	if err := p.scanImports(); err != nil {
+		return err
+	}
+	if err := p.scanModules(); err != nil {
+		return err
+	}
+	if p.IsolateScopes {
+		p.scanGlobals()
+	}
+
+	var css, js, tmpls, data, workers []*input
+	for _, f := range p.files {
+		if p.placed[f] || p.imported[f] || p.modules[f] != nil && !p.modules[f].entry {
+			continue
+		}
+		switch {
+		case p.isData(f):
+			data = append(data, f)
+		case p.isWorker(f):
+			workers = append(workers, f)
+		case p.isFragment(f):
+			tmpls = append(tmpls, f)
+		case f.kind == KindJS && !(len(p.Entries) > 0 && inNodeModules(f.path)):
+			js = append(js, f)
+		case f.kind == KindCSS:
+			css = append(css, f)
+		}
+	}
+
+	if p.data.Templates, err = p.fragments(tmpls); err != nil {
+		return err
+	}
+
+	if p.data.CSS, err = p.element(KindCSS, css, nil, ""); err != nil {
+		return err
+	}
+	if p.data.Javascript, err = p.element(KindJS, js, p.reload, ""); err != nil {
+		return err
+	}
+
+	if workers, err := p.workerElements(workers); err != nil {
+		return err
+	} else if workers != "" {
+		p.data.Javascript = workers + p.data.Javascript
+	}
+
+	if data, err := p.dataElements(data); err != nil {
+		return err
+	} else if data != "" {
+		p.data.Javascript = data + p.data.Javascript
+	}
+
+	if !writesTemplates && p.data.Templates != "" {
+		p.data.Javascript = p.data.Templates + p.data.Javascript
+		p.data.Templates = ""
+	}
+
+	return tmpl.Execute(out, p.data)
+}
+
+// Returns the functions available to the HTML template.  Each takes
+// a path or glob relative to the input directory, with '**' matching
+// any number of directories, and selects the files that match in
+// output order:
+//
+//	css        style element with the matching CSS files
+//	js         script element with the matching Javascript files
+//	templates  template elements with the matching HTML fragment
+//	           files
+//	data       elements with the matching data files and the
+//	           wpp.data accessor
+//	workers    elements with the matching worker files and the
+//	           wpp.worker helper
+//	include    matching files executed as templates with the same
+//	           data and functions
+//	raw        contents of the matching files as is
+//
+// Files selected by any of them are left out of .CSS and .Javascript.
+func (p *page) funcs() template.FuncMap {
+	return template.FuncMap{
+		"css": func(pattern string) (string, error) {
+			if files := p.match("css", pattern, KindCSS); len(files) > 0 {
+				return p.element(KindCSS, files, nil, "")
+			}
+			return "", nil
+		},
+		"js": func(pattern string) (string, error) {
+			if files := p.match("js", pattern, KindJS); len(files) > 0 {
+				return p.element(KindJS, files, nil, "")
+			}
+			return "", nil
+		},
+		"templates": func(pattern string) (string, error) {
+			return p.fragments(p.matchFunc("templates", pattern, p.isFragment))
+		},
+		"data": func(pattern string) (string, error) {
+			return p.dataElements(p.matchFunc("data", pattern, p.isData))
+		},
+		"workers": func(pattern string) (string, error) {
+			return p.workerElements(p.matchFunc("workers", pattern, p.isWorker))
+		},
+		"include": p.include,
+		"raw":     p.raw,
+		"wppTag":  p.tag,
+	}
+}
+
+// Returns the files matching pattern, of kind k unless k is
+// KindOther, and marks them as placed.  Name is the template function
+// doing the matching and is used to warn of a pattern that matches no
+// files.
+func (p *page) match(name, pattern string, k Kind) []*input {
+	return p.matchFunc(name, pattern, func(f *input) bool {
+		return k == KindOther || f.kind == k
+	})
+}
+
+// Returns the files matching pattern for which keep reports true and
+// marks them as placed.
+func (p *page) matchFunc(name, pattern string, keep func(*input) bool) []*input {
+	var (
+		matches []*input
+		clean   = cleanPattern(pattern)
+	)
+
+	for _, f := range p.files {
+		if keep(f) && matchPath(clean, f.path) {
+			matches = append(matches, f)
+			p.placed[f] = true
+		}
+	}
+
+	if len(matches) == 0 && p.claiming {
+		wlog(fmt.Sprintf(`Template function {{%s %q}} does not match any file`, name, pattern))
+	}
+	return matches
+}
+
+func (p *page) include(pattern string) (string, error) {
+	var out bytes.Buffer
+
+	for _, f := range p.match("include", pattern, KindOther) {
+		if p.including[f.path] {
+			return "", fmt.Errorf("%s includes itself", f.path)
+		}
+
+		data, err := f.contents()
+		if err != nil {
+			return "", err
+		}
+
+		html := string(data)
+		if !p.claiming {
+			if html, err = p.embedHTML(f.path, html); err != nil {
+				return "", err
+			}
+		}
+
+		tmpl, err := template.New(f.path).Funcs(p.funcs()).Parse(html)
+		if err != nil {
+			return "", err
+		}
+
+		p.including[f.path] = true
+		err = tmpl.Execute(&out, p.data)
+		delete(p.including, f.path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return out.String(), nil
+}
+
+func (p *page) raw(pattern string) (string, error) {
+	var out bytes.Buffer
+
+	for _, f := range p.match("raw", pattern, KindOther) {
+		data, err := f.contents()
+		if err != nil {
+			return "", err
+		}
+		out.Write(data)
+	}
+
+	return out.String(), nil
+}
+
+// Returns the style elements, for kind KindCSS, or script elements,
+// for kind KindJS, that inline files followed by the code extra, which
+// may be nil.  Attrs are the attributes of the elements' start tags,
+// each with a leading space, or empty for those ElementAttrs gives
+// each file.  Consecutive files with the same attributes share an
+// element so the order of files is kept, and extra goes last, sharing
+// the last element when it has the attributes for every file.  Nothing
+// is built while claiming.
+//
+// With SplitFiles each file gets an element of its own, naming the
+// file in a data-wpp-src attribute.
+func (p *page) element(k Kind, files []*input, extra []byte, attrs string) (string, error) {
+	if p.claiming {
+		return "", nil
+	}
+
+	type group struct {
+		attrs string
+		files []*input
+		extra []byte
+	}
+
+	var (
+		groups []*group
+		find   = func(attrs string) *group {
+			if n := len(groups); n > 0 && groups[n-1].attrs == attrs {
+				return groups[n-1]
+			}
+			g := &group{attrs: attrs}
+			groups = append(groups, g)
+			return g
+		}
+	)
+
+	for _, f := range files {
+		a := attrs
+		if a == "" {
+			a = p.elementAttrs(k, f)
+		}
+		if p.SplitFiles {
+			a += ` data-wpp-src="` + template.HTMLEscapeString(f.path) + `"`
+		}
+		g := find(a)
+		g.files = append(g.files, f)
+	}
+	if extra != nil || len(groups) == 0 {
+		a := attrs
+		if a == "" {
+			a = p.elementAttrs(k, nil)
+		}
+		find(a).extra = extra
+	}
+
+	var elems []string
+	for _, g := range groups {
+		e, err := p.block(k, g.files, g.extra, g.attrs)
+		if err != nil {
+			return "", err
+		} else if e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return strings.Join(elems, "\n"), nil
+}
+
+// Returns a single element of kind k, with the attributes attrs, that
+// inlines files followed by the code extra.  The element is left out
+// if none of files are left to inline and there is no extra.
+func (p *page) block(k Kind, files []*input, extra []byte, attrs string) (string, error) {
+	var (
+		open, close string
+		imports     []string
+		u           = bundle{banners: p.Banners}
+		smap        = indexMap{Version: 3}
+	)
+
+	module := k == KindJS && strings.EqualFold(attrValue(parseAttrs(attrs), "type"), "module")
+	if k == KindJS {
+		open, close = "<script"+attrs+">", "</script>"
+		u.sep = ";\n"
+	} else {
+		open, close = "<style"+attrs+">", "</style>"
+	}
+
+	for _, f := range files {
+		if err := p.ctx.Err(); err != nil {
+			return "", err
+		}
+
+		if k == KindCSS {
+			if p.inlined[f] {
+				continue
+			}
+			data, ext, err := p.style(f, nil)
+			if err != nil {
+				return "", err
+			}
+			imports = append(imports, ext...)
+			u.add(f.path, data)
+			continue
+		}
+
+		var (
+			data     []byte
+			sections []mapSection
+			err      error
+		)
+		if m := p.modules[f]; m != nil {
+			if !m.entry {
+				wlog(f.path, "is bundled by the entries that reach it and so is not inlined on its own")
+				continue
+			}
+			data, sections, err = p.bundleModules(m)
+		} else {
+			data, sections, err = p.script(f, module)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		line := u.add(f.path, data)
+		for _, s := range sections {
+			s.Offset.Line += line
+			smap.Sections = append(smap.Sections, s)
+		}
+	}
+
+	if len(files) > 0 && u.count == 0 && extra == nil {
+		return "", nil
+	}
+	if extra != nil {
+		u.add("", extra)
+	}
+
+	if p.SourceMap && k == KindJS {
+		comment, err := smap.comment()
+		if err != nil {
+			return "", err
+		}
+		u.WriteString(comment)
+	}
+
+	if len(imports) > 0 {
+		return open + strings.Join(imports, "\n") + "\n" + u.String() + close, nil
+	}
+	return open + u.String() + close, nil
+}
+
+// Returns the Javascript of f ready to be inlined into a script
+// element, a module script when module is set, along with its source
+// map sections when SourceMap is set.  With IsolateScopes the
+// Javascript runs in a function of its own.
+func (p *page) script(f *input, module bool) ([]byte, []mapSection, error) {
+	data, err := p.transformed(p.ctx, f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sections []mapSection
+	if p.SourceMap {
+		if data, sections, err = sourceSections(f, data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if data, err = escapeScript(f.path, data, module); err != nil {
+		return nil, nil, err
+	}
+
+	if p.IsolateScopes {
+		data, sections = isolate(data, sections)
+	}
+	return data, sections, nil
+}
@@ -0,0 +1,261 @@
+package wpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions tried, in order, for a module specifier that leaves out
+// its extension.
+var moduleExts = []string{".js", ".mjs", ".cjs", ".json"}
+
+// The fields of a package.json file that decide which of its files a
+// module specifier resolves to.
+type packageJSON struct {
+	// Directory of the package relative to the input directories.
+	dir string
+
+	Main    string          `json:"main"`
+	Browser json.RawMessage `json:"browser"`
+
+	// The browser field when it is a string replacing main.
+	browserMain string
+
+	// The browser field when it is an object replacing files or
+	// packages.  A nil value replaces them with an empty module.
+	browserMap map[string]*string
+}
+
+// Returns the module that the specifier of the import or require
+// spec made by the file from resolves to.  Relative specifiers are
+// resolved against the directory of from, or against the input
+// directories if they start with '/', and bare specifiers against the
+// node_modules directories of from's directory and its parents, the
+// way Node does, honoring the browser and main fields of package.json
+// files.  A specifier may leave out its extension or name a directory
+// holding an index file.
+func (p *page) resolveModule(from *input, spec jsToken) (*input, error) {
+	var (
+		ref    = spec.value()
+		pkg    = p.packageOf(from.path)
+		target *input
+	)
+
+	if pkg != nil && pkg.browserMap != nil && !isRelative(ref) {
+		if to, ok := pkg.browserMap[ref]; ok {
+			if to == nil {
+				return p.empty, nil
+			}
+			ref = *to
+			if isRelative(ref) {
+				ref = "/" + path.Join(pkg.dir, ref)
+			}
+		}
+	}
+
+	switch {
+	case isRelative(ref):
+		target = p.resolvePath(path.Join(path.Dir(from.path), ref))
+	case strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "//"):
+		target = p.resolvePath(path.Join(".", ref))
+	case !isAbsoluteURL(ref) && ref != "":
+		for dir := path.Dir(from.path); target == nil; dir = path.Dir(dir) {
+			if path.Base(dir) != "node_modules" {
+				target = p.resolvePath(path.Join(dir, "node_modules", ref))
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+
+	if target == nil {
+		return nil, fmt.Errorf("%s:%d: %q does not resolve to a file in the input directories or their node_modules",
+			from.path, spec.line, spec.value())
+	}
+	return p.browserFile(target), nil
+}
+
+// Returns the module file at the slash separated path name, which may
+// leave out its extension or be a package or a directory with an index
+// file, or nil if there is none.
+func (p *page) resolvePath(name string) *input {
+	if strings.HasPrefix(name, "../") || name == ".." {
+		return nil
+	}
+
+	if f := p.moduleFile(name); f != nil {
+		return f
+	}
+	for _, ext := range moduleExts {
+		if f := p.moduleFile(name + ext); f != nil {
+			return f
+		}
+	}
+
+	if pkg := p.packageJSON(name); pkg != nil {
+		main := pkg.Main
+		if pkg.browserMain != "" {
+			main = pkg.browserMain
+		}
+		if main != "" && path.Join(name, main) != name {
+			if f := p.resolvePath(path.Join(name, main)); f != nil {
+				return f
+			}
+		}
+	}
+
+	for _, ext := range moduleExts {
+		if f := p.moduleFile(path.Join(name, "index"+ext)); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// Returns the file that replaces f according to the browser field of
+// the package f is in, which may be the empty module, or f itself.
+func (p *page) browserFile(f *input) *input {
+	pkg := p.packageOf(f.path)
+	if pkg == nil || pkg.browserMap == nil {
+		return f
+	}
+
+	rel := strings.TrimPrefix(f.path, pkg.dir+"/")
+	for _, key := range []string{"./" + rel, rel, "./" + strings.TrimSuffix(rel, path.Ext(rel))} {
+		to, ok := pkg.browserMap[key]
+		if !ok {
+			continue
+		} else if to == nil {
+			return p.empty
+		} else if r := p.resolvePath(path.Join(pkg.dir, *to)); r != nil {
+			return r
+		}
+	}
+	return f
+}
+
+// Returns the Javascript or JSON file at the slash separated path name
+// or nil if there is none.  Files in node_modules directories are read
+// from the input directories even if ignored, as node_modules usually
+// is.
+func (p *page) moduleFile(name string) *input {
+	f := p.byPath[name]
+	if f == nil && inNodeModules(name) {
+		f = p.diskFile(name)
+	}
+	if f == nil || f.kind != KindJS && strings.ToLower(path.Ext(f.path)) != ".json" {
+		return nil
+	}
+	return f
+}
+
+// Returns the regular file at the slash separated path name in the
+// last input directory that has one, or nil if there is none.
+func (p *page) diskFile(name string) *input {
+	if f, ok := p.disk[name]; ok {
+		return f
+	}
+
+	var f *input
+	for i := len(p.InputDirs) - 1; i >= 0 && f == nil; i-- {
+		abs := filepath.Join(p.InputDirs[i], filepath.FromSlash(name))
+		if info, err := os.Stat(abs); err == nil && info.Mode().IsRegular() {
+			f = &input{path: name, abs: abs, info: info, kind: p.kind(name)}
+		}
+	}
+
+	p.disk[name] = f
+	return f
+}
+
+// Returns the package.json of the package in the slash separated
+// directory dir or nil if it has none or it can not be read.
+func (p *page) packageJSON(dir string) *packageJSON {
+	if pkg, ok := p.packages[dir]; ok {
+		return pkg
+	}
+	p.packages[dir] = nil
+
+	name := path.Join(dir, "package.json")
+	f := p.byPath[name]
+	if f == nil && inNodeModules(name) {
+		f = p.diskFile(name)
+	}
+	if f == nil {
+		return nil
+	}
+
+	data, err := f.contents()
+	if err != nil {
+		wlog("Could not read", f.path, "--", err)
+		return nil
+	}
+
+	pkg := &packageJSON{dir: dir}
+	if err := json.Unmarshal(data, pkg); err != nil {
+		wlog("Could not parse", f.path, "--", err)
+		return nil
+	}
+
+	if len(pkg.Browser) > 0 {
+		var browser interface{}
+		json.Unmarshal(pkg.Browser, &browser)
+
+		switch b := browser.(type) {
+		case string:
+			pkg.browserMain = b
+		case map[string]interface{}:
+			pkg.browserMap = make(map[string]*string, len(b))
+			for k, v := range b {
+				if s, ok := v.(string); ok {
+					pkg.browserMap[k] = &s
+				} else if v == false {
+					pkg.browserMap[k] = nil
+				}
+			}
+			if to, ok := pkg.browserMap["./"+strings.TrimPrefix(path.Clean(pkg.Main), "./")]; ok && to != nil {
+				pkg.browserMain = *to
+			}
+		}
+	}
+
+	p.packages[dir] = pkg
+	return pkg
+}
+
+// Returns the package.json of the package in node_modules that the
+// slash separated path name is in, or nil if it is in none.
+func (p *page) packageOf(name string) *packageJSON {
+	parts := strings.Split(name, "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if parts[i] != "node_modules" || i+1 >= len(parts)-1 {
+			continue
+		}
+		n := i + 2
+		if strings.HasPrefix(parts[i+1], "@") {
+			n++
+		}
+		if n > len(parts)-1 {
+			continue
+		}
+		return p.packageJSON(strings.Join(parts[:n], "/"))
+	}
+	return nil
+}
+
+// Reports whether the slash separated path name is within a
+// node_modules directory.
+func inNodeModules(name string) bool {
+	return strings.HasPrefix(name, "node_modules/") || strings.Contains(name, "/node_modules/")
+}
+
+// Reports whether the module specifier ref is relative to the file
+// that uses it.
+func isRelative(ref string) bool {
+	return ref == "." || ref == ".." || strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../")
+}
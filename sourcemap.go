@@ -0,0 +1,198 @@
+package wpp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matches a "//# sourceMappingURL=" comment, or its older "//@" form,
+// on a line of its own.
+var sourceMappingURL = regexp.MustCompile(`(?m)^[ \t]*//[#@][ \t]*sourceMappingURL=([^\s'"]+)[ \t]*\r?$\n?`)
+
+// An index source map made of the maps of consecutive sections of
+// the output.  See https://sourcemaps.info/spec.html.
+type indexMap struct {
+	Version  int          `json:"version"`
+	Sections []mapSection `json:"sections"`
+}
+
+type mapSection struct {
+	Offset mapOffset  `json:"offset"`
+	Map    *sourceMap `json:"map"`
+}
+
+type mapOffset struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// A regular source map.  Sections is only used when decoding a map
+// from an upstream tool that is itself an index map.
+type sourceMap struct {
+	Version        int          `json:"version"`
+	File           string       `json:"file,omitempty"`
+	SourceRoot     string       `json:"sourceRoot,omitempty"`
+	Sources        []string     `json:"sources"`
+	SourcesContent []*string    `json:"sourcesContent,omitempty"`
+	Names          []string     `json:"names"`
+	Mappings       string       `json:"mappings"`
+	Sections       []mapSection `json:"sections,omitempty"`
+}
+
+// Returns the "//# sourceMappingURL=" comment that inlines m as a data
+// URI.
+func (m *indexMap) comment() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return "//# sourceMappingURL=data:application/json;charset=utf-8;base64," +
+		base64.StdEncoding.EncodeToString(b) + "\n", nil
+}
+
+// Returns the sections mapping the Javascript data of f back to its
+// origin, relative to the start of data, along with data stripped of
+// any "//# sourceMappingURL=" comment.  If data refers to a source map
+// from an upstream tool then that map is used, otherwise every line
+// maps to the same line of f.
+func sourceSections(f *input, data []byte) ([]byte, []mapSection, error) {
+	loc := sourceMappingURL.FindAllSubmatchIndex(data, -1)
+	if len(loc) == 0 {
+		return data, identitySections(f.path, data), nil
+	}
+
+	last := loc[len(loc)-1]
+	ref := string(data[last[2]:last[3]])
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, data[:last[0]]...)
+	stripped = append(stripped, data[last[1]:]...)
+
+	m, dir, err := loadSourceMap(f, ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: could not load source map %s -- %v", f.path, ref, err)
+	} else if m == nil {
+		wlog(f.path, "refers to source map", ref, "which can not be inlined")
+		return stripped, identitySections(f.path, stripped), nil
+	}
+
+	return stripped, flattenSections(m, dir, mapOffset{}), nil
+}
+
+// Returns the sections of an upstream map m, which may itself be an
+// index map, offset by off and with its sources made relative to the
+// input directory from dir, the directory of the map.
+func flattenSections(m *sourceMap, dir string, off mapOffset) []mapSection {
+	if len(m.Sections) == 0 {
+		for i, src := range m.Sources {
+			m.Sources[i] = resolveSource(dir, m.SourceRoot, src)
+		}
+		m.SourceRoot = ""
+		m.File = ""
+		return []mapSection{{Offset: off, Map: m}}
+	}
+
+	var sections []mapSection
+	for _, s := range m.Sections {
+		o := mapOffset{Line: off.Line + s.Offset.Line, Column: s.Offset.Column}
+		if s.Offset.Line == 0 {
+			o.Column += off.Column
+		}
+		if s.Map != nil {
+			sections = append(sections, flattenSections(s.Map, dir, o)...)
+		}
+	}
+	return sections
+}
+
+// Returns the path of the source src of a map found in dir.
+func resolveSource(dir, root, src string) string {
+	if strings.Contains(src, "://") || strings.HasPrefix(src, "/") {
+		return src
+	} else if strings.Contains(root, "://") {
+		return strings.TrimSuffix(root, "/") + "/" + src
+	}
+	return path.Join(dir, root, src)
+}
+
+// Loads the source map ref refers to from the Javascript file f and
+// returns it along with its directory relative to the input
+// directory.  Ref is either a data URI or a path relative to f.  The
+// map is nil if ref refers to anything else.
+func loadSourceMap(f *input, ref string) (*sourceMap, string, error) {
+	var (
+		b   []byte
+		dir = path.Dir(f.path)
+		err error
+	)
+
+	if strings.HasPrefix(ref, "data:") {
+		i := strings.IndexByte(ref, ',')
+		if i < 0 {
+			return nil, "", fmt.Errorf("malformed data URI")
+		}
+		if strings.HasSuffix(ref[:i], ";base64") {
+			b, err = base64.StdEncoding.DecodeString(ref[i+1:])
+		} else {
+			var s string
+			s, err = url.PathUnescape(ref[i+1:])
+			b = []byte(s)
+		}
+	} else if strings.Contains(ref, "://") || strings.HasPrefix(ref, "/") {
+		return nil, "", nil
+	} else {
+		var name string
+		if name, err = url.PathUnescape(ref); err != nil {
+			return nil, "", err
+		}
+		b, err = ioutil.ReadFile(filepath.Join(filepath.Dir(f.abs), filepath.FromSlash(name)))
+		dir = path.Dir(path.Join(dir, name))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var m sourceMap
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, "", err
+	}
+	return &m, dir, nil
+}
+
+// Returns the single section that maps each line of data to the same
+// line of the file name and includes data as the source's content.
+func identitySections(name string, data []byte) []mapSection {
+	n := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// The first line maps to the first line of the only source and
+	// each following line to the next line of that source.
+	var mappings strings.Builder
+	mappings.WriteString("AAAA")
+	for i := 1; i < n; i++ {
+		mappings.WriteString(";AACA")
+	}
+
+	content := string(data)
+	return []mapSection{{
+		Map: &sourceMap{
+			Version:        3,
+			Sources:        []string{name},
+			SourcesContent: []*string{&content},
+			Names:          []string{},
+			Mappings:       mappings.String(),
+		},
+	}}
+}
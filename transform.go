@@ -0,0 +1,128 @@
+package wpp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Transform is an external command that the contents of files with
+// a given extension are piped through before they are inlined.
+type Transform struct {
+	// Extension, including the leading dot, of the files the command
+	// transforms.
+	Ext string
+
+	// Extension of the command's output which decides where the
+	// output is inlined, e.g. ".css" for a command that compiles
+	// ".scss" files.  If empty then Ext is used.
+	OutExt string
+
+	// The command and its arguments.  The command is run in the
+	// directory of the file with the file's contents on its standard
+	// input and its standard output is inlined in place of the
+	// contents.
+	Command []string
+}
+
+// ParseTransform parses a transform of the form
+// "ext[:outext]=command [args...]" such as ".scss:.css=sass --stdin".
+// The command and its arguments are separated by white space.
+func ParseTransform(s string) (Transform, error) {
+	var t Transform
+
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return t, fmt.Errorf(`Transform %q is not of the form "ext[:outext]=command"`, s)
+	}
+
+	exts := strings.SplitN(s[:i], ":", 2)
+	t.Ext = normalizeExt(exts[0])
+	if len(exts) == 2 {
+		t.OutExt = normalizeExt(exts[1])
+	}
+	t.Command = strings.Fields(s[i+1:])
+
+	if t.Ext == "" || len(exts) == 2 && t.OutExt == "" {
+		return t, fmt.Errorf("Transform %q has an empty extension", s)
+	} else if len(t.Command) == 0 {
+		return t, fmt.Errorf("Transform %q has no command", s)
+	}
+	return t, nil
+}
+
+// Returns ext in lower case with a leading dot, or empty if ext is
+// blank.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && ext[0] != '.' {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// Returns the transform for the file name or nil if it has none.
+func (b *Builder) transform(name string) *Transform {
+	ext := strings.ToLower(path.Ext(name))
+	for i := range b.Transforms {
+		if normalizeExt(b.Transforms[i].Ext) == ext {
+			return &b.Transforms[i]
+		}
+	}
+	return nil
+}
+
+// Returns the contents of f once piped through its transform, or as
+// is if it has none.  The command failing or exiting with a non-zero
+// status is an error naming f along with the command's standard
+// error.  Standard error output from a command that succeeds is logged
+// as a warning.
+func (b *Builder) transformed(ctx context.Context, f *input) ([]byte, error) {
+	if f.output != nil {
+		return f.output, nil
+	}
+
+	data, err := f.contents()
+	if err != nil {
+		return nil, err
+	}
+
+	t := b.transform(f.path)
+	if t == nil || len(t.Command) == 0 {
+		f.output = data
+		return data, nil
+	}
+
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+		cmd    = exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+		line   = strings.Join(t.Command, " ")
+	)
+	cmd.Dir = filepath.Dir(f.abs)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	b.vlog(fmt.Sprintf(`Transforming %s with "%s"`, f.path, line))
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf(`%s: "%s" failed -- %v`, f.path, line, err)
+		}
+		return nil, fmt.Errorf("%s: \"%s\" failed -- %v\n%s", f.path, line, err, msg)
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		wlog(fmt.Sprintf("%s: \"%s\" wrote to standard error:\n%s", f.path, line, msg))
+	}
+
+	f.output = stdout.Bytes()
+	if f.output == nil {
+		f.output = []byte{}
+	}
+	return f.output, nil
+}
@@ -0,0 +1,345 @@
+package wpp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/0xABAD/filewatch"
+	"github.com/gorilla/websocket"
+)
+
+// Watch continuously rebuilds the output whenever a file in the input
+// directories that is not ignored, or the template file, changes until
+// ctx is done.  If both ReloadPort and Outfile are set then Outfile
+// is served on localhost and a small snippet of Javascript is
+// inserted into the output so the browser reloads the page after
+// every build.
+//
+// Build errors are logged and do not stop Watch.  An error is only
+// returned if watching could not be started.
+func (b *Builder) Watch(ctx context.Context) error {
+	var (
+		err        error
+		out        io.Writer
+		file       *os.File
+		port       uint
+		server     *http.Server
+		isReady    = true
+		pending    = false
+		ready      = make(chan error)
+		done       = make(chan struct{})
+		newconn    = make(chan *websocket.Conn)
+		connclosed = make(chan *websocket.Conn)
+		conns      = make(map[*websocket.Conn]bool)
+	)
+	defer close(done)
+
+	if b.Outfile == "" {
+		b.vlog("Dev mode with no outfile can not serve files and hot reload.")
+		if out = b.Out; out == nil {
+			out = os.Stdout
+		}
+	} else {
+		if file, err = CreateOutfile(b.Outfile); err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+		port = b.ReloadPort
+	}
+
+	var (
+		updates = make(chan []filewatch.Update)
+		watched = make(map[string]bool)
+	)
+
+	// Watches dir and forwards its updates to the updates channel.
+	watchDir := func(dir string) error {
+		dirUpdates, err := filewatch.Watch(done, dir, true, nil)
+		if err != nil {
+			return fmt.Errorf("Could not watch %s directory -- %v", dir, err)
+		}
+		watched[dir] = true
+
+		// Skip initial updates as the initial template update will
+		// drive the first output.
+		<-dirUpdates
+
+		go (func() {
+			for {
+				select {
+				case us := <-dirUpdates:
+					select {
+					case updates <- us:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		})()
+		return nil
+	}
+
+	// Directories reached through symbolic links are watched as
+	// well since they are not part of the input directories on disk.
+	watchLinked := func(linked []string) {
+		for _, dir := range linked {
+			if !watched[dir] {
+				b.vlog("Watching linked directory", dir)
+				if err := watchDir(dir); err != nil {
+					elog(err)
+				}
+			}
+		}
+	}
+
+	for _, dir := range b.InputDirs {
+		if err := watchDir(dir); err != nil {
+			return err
+		}
+	}
+
+	// Updates only matter when they change the files a build reads.
+	last, linked, err := b.snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s -- %v", b.inputs(), err)
+	}
+	watchLinked(linked)
+
+	var tmplUpdate <-chan []filewatch.Update
+	if b.Template != "" {
+		tmplUpdate, err = filewatch.Watch(done, b.Template, false, nil)
+		if err != nil {
+			return fmt.Errorf("Could not watch template file, %s -- %v", b.Template, err)
+		}
+	} else {
+		ch := make(chan []filewatch.Update, 1)
+		ch <- make([]filewatch.Update, 0)
+		tmplUpdate = (<-chan []filewatch.Update)(ch)
+	}
+
+	for {
+		select {
+		case <-updates:
+			snap, linked, err := b.snapshot(ctx)
+			if err != nil {
+				elog("Failed to read", b.inputs(), " --", err)
+				break
+			}
+
+			watchLinked(linked)
+			if name, changed := snap.changed(last); changed {
+				if !pending {
+					b.vlog("Detected change of file", name)
+				}
+				pending = true
+				last = snap
+			}
+		case <-tmplUpdate:
+			pending = true
+			if b.Template != "" {
+				b.vlog("Detected change of HTML template:", b.Template)
+			}
+		case err := <-ready:
+			b.vlog("Finished processing file changes, set isReady to true")
+			isReady = true
+
+			if err != nil {
+				elog(err)
+			} else if port > 0 {
+				if server == nil {
+					server = b.serve(port, newconn, connclosed, done)
+					if b.OnServe != nil {
+						b.OnServe()
+					}
+				} else {
+					for c := range conns {
+						t := websocket.TextMessage
+						if err = c.WriteMessage(t, []byte("reload")); err != nil {
+							elog(`Failed to write "reload" web socket message`, err)
+						}
+					}
+				}
+			} else if file == nil {
+				fmt.Fprintln(out) // additional newline
+			}
+		case c := <-newconn:
+			conns[c] = true
+		case c := <-connclosed:
+			delete(conns, c)
+		case <-ctx.Done():
+			if !isReady {
+				<-ready
+			}
+			if server != nil {
+				server.Close()
+			}
+			for c := range conns {
+				c.Close()
+			}
+			return nil
+		}
+
+		if isReady && pending {
+			isReady = false
+			pending = false
+
+			go (func() {
+				ready <- b.rebuild(ctx, file, out, port)
+			})()
+		}
+	}
+}
+
+// Rebuilds the output for Watch.  If file is not nil then it is
+// truncated before the build and synced afterwards.
+func (b *Builder) rebuild(ctx context.Context, file *os.File, out io.Writer, port uint) error {
+	if file != nil {
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("Failed to truncate outfile, %s -- %v", b.Outfile, err)
+		} else if _, err = file.Seek(0, 0); err != nil {
+			return fmt.Errorf("Failed to seek to beginning of outfile, %s -- %v", b.Outfile, err)
+		}
+	}
+
+	if err := b.build(ctx, out, port); err != nil {
+		return fmt.Errorf("Failed to pre-process %s -- %v", b.inputs(), err)
+	}
+
+	if file != nil {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("Failed to sync outfile, %s -- %v", b.Outfile, err)
+		}
+	}
+	return nil
+}
+
+// The size and modification time of every file a build reads keyed
+// by path.
+type snapshot map[string]stamp
+
+type stamp struct {
+	size    int64
+	modtime time.Time
+}
+
+// Returns the snapshot of the files gathered for a build along with
+// the directories that followed symbolic links resolve to.  Gathering
+// them the same way as a build means that Watch never disagrees with
+// a build about which files matter.
+func (b *Builder) snapshot(ctx context.Context) (snapshot, []string, error) {
+	files, linked, err := b.gather(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snap := make(snapshot, len(files))
+	for _, f := range files {
+		snap[f.path] = stamp{f.info.Size(), f.info.ModTime()}
+	}
+	return snap, linked, nil
+}
+
+// Returns the path of a file that was added, removed or modified
+// since the snapshot prev and whether there is any such file.
+func (s snapshot) changed(prev snapshot) (string, bool) {
+	for name, st := range s {
+		if p, ok := prev[name]; !ok || p.size != st.size || !p.modtime.Equal(st.modtime) {
+			return name, true
+		}
+	}
+	for name := range prev {
+		if _, ok := s[name]; !ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Starts the dev server that serves Outfile and accepts web socket
+// connections for hot reloading.
+func (b *Builder) serve(port uint, newconn, connclosed chan<- *websocket.Conn, done <-chan struct{}) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.index)
+	mux.HandleFunc("/wpphotreload", b.reload(newconn, connclosed, done))
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+
+	go (func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			elog("Failed to start HTTP web server on localhost --", err)
+		}
+	})()
+
+	return server
+}
+
+func (b *Builder) index(w http.ResponseWriter, r *http.Request) {
+	if b.Outfile != "" {
+		http.ServeFile(w, r, b.Outfile)
+	}
+}
+
+func (b *Builder) reload(newconn, connclosed chan<- *websocket.Conn, done <-chan struct{}) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		}
+
+		sock, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			elog("Could not updgrade HTTP request to websocket --", err)
+			return
+		}
+		defer sock.Close()
+
+		select {
+		case newconn <- sock:
+		case <-done:
+			return
+		}
+
+		for {
+			msgtype, msg, err := sock.ReadMessage()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					b.vlog("Websocket connection closed --", err)
+				} else {
+					elog("Error reading web socket message --", err)
+				}
+				break
+			} else {
+				var result string
+
+				switch msgtype {
+				case websocket.TextMessage:
+					result = fmt.Sprintf("Received web socket text message: %s", msg)
+				case websocket.BinaryMessage:
+					result = "Received web socket binary message"
+				case websocket.CloseMessage:
+					result = "Received web socket close message"
+				case websocket.PingMessage:
+					result = "Received web socket ping message"
+				case websocket.PongMessage:
+					result = "Received web socket pong message"
+				}
+				b.vlog(result)
+			}
+		}
+
+		select {
+		case connclosed <- sock:
+		case <-done:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package wpp
+
+import (
+	"strings"
+	"text/template"
+)
+
+// The glob of the Javascript files inlined as web workers unless
+// Workers says otherwise.
+const DefaultWorkers = "workers"
+
+// Code that defines wpp.worker, which starts a web worker from the
+// Javascript of a worker element.
+const workerHelper = `(function (wpp) {
+wpp.worker = wpp.worker || function (name, options) {
+  var el = document.getElementById(name);
+  if (!el || el.type !== "text/js-worker") {
+    throw new Error("No worker " + name);
+  }
+  var blob = new Blob([el.textContent], {type: "text/javascript"});
+  return new Worker(URL.createObjectURL(blob), options);
+};
+})(self.wpp || (self.wpp = {exports: {}}));
+`
+
+// Reports whether f is a web worker file.
+func (b *Builder) isWorker(f *input) bool {
+	pattern := b.Workers
+	if pattern == "" {
+		pattern = DefaultWorkers
+	}
+	return f.kind == KindJS && matchPath(cleanPattern(pattern), f.path)
+}
+
+// Returns a worker element for each of the worker files, identified by
+// the file's path, followed by a script defining wpp.worker, which
+// starts a Worker running the file with the given path.  Nothing is
+// built while claiming.
+func (p *page) workerElements(files []*input) (string, error) {
+	if p.claiming || len(files) == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	for _, f := range files {
+		data, err := p.transformed(p.ctx, f)
+		if err != nil {
+			return "", err
+		}
+		if data, err = escapeScript(f.path, data, false); err != nil {
+			return "", err
+		}
+
+		out.WriteString(`<script type="text/js-worker" id="` + template.HTMLEscapeString(f.path) + `">`)
+		out.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			out.WriteString("\n")
+		}
+		out.WriteString("</script>\n")
+	}
+
+	script, err := p.block(KindJS, nil, []byte(workerHelper), p.elementAttrs(KindJS, nil))
+	if err != nil {
+		return "", err
+	}
+	return out.String() + script + "\n", nil
+}